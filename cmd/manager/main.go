@@ -13,6 +13,7 @@ import (
 
 	"github.com/alaypatel07/etcd-cert-signer/pkg/apis"
 	"github.com/alaypatel07/etcd-cert-signer/pkg/controller"
+	"github.com/alaypatel07/etcd-cert-signer/pkg/controller/etcdcertsigner"
 
 	"github.com/operator-framework/operator-sdk/pkg/k8sutil"
 	kubemetrics "github.com/operator-framework/operator-sdk/pkg/kube-metrics"
@@ -24,6 +25,7 @@ import (
 	"github.com/spf13/pflag"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
@@ -53,6 +55,8 @@ func main() {
 	// controller-runtime)
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
 
+	ocspBindAddress := pflag.String("ocsp-bind-address", "", "Address the OCSP responder for certificates issued by the etcd CA listens on, e.g. :8889. The responder is disabled when empty.")
+
 	pflag.Parse()
 
 	// Use a zap logr.Logger implementation. If none of the zap
@@ -107,12 +111,40 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Refuse to start with invalid operator settings, the cache is not started yet
+	settingsClient, err := client.New(cfg, client.Options{Scheme: mgr.GetScheme()})
+	if err != nil {
+		log.Error(err, "")
+		os.Exit(1)
+	}
+	settings, err := etcdcertsigner.LoadOperatorSettings(settingsClient)
+	if err != nil {
+		log.Error(err, "Invalid operator settings")
+		os.Exit(1)
+	}
+
+	// Read the CA secrets from a cache of their own when their namespace is not watched
+	if namespace != "" && settings.CASecretNamespace != namespace {
+		mgr, err = controller.WithNamespace(mgr, settings.CASecretNamespace)
+		if err != nil {
+			log.Error(err, "")
+			os.Exit(1)
+		}
+	}
+
 	// Setup all Controllers
 	if err := controller.AddToManager(mgr); err != nil {
 		log.Error(err, "")
 		os.Exit(1)
 	}
 
+	if *ocspBindAddress != "" {
+		if err := mgr.Add(etcdcertsigner.NewOCSPResponder(mgr, *ocspBindAddress)); err != nil {
+			log.Error(err, "")
+			os.Exit(1)
+		}
+	}
+
 	if err = serveCRMetrics(cfg); err != nil {
 		log.Info("Could not generate and serve custom resource metrics", "error", err.Error())
 	}
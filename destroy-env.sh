@@ -1,3 +1,3 @@
-kubectl delete secret etcd-ca etcd-1-peer etcd-1-server etcd-2-peer etcd-2-server etcd-3-peer etcd-3-server
-kubectl delete secret etcd-ca
+kubectl delete secret etcd-ca etcd-1-peer etcd-1-server etcd-1-metrics etcd-2-peer etcd-2-server etcd-2-metrics etcd-3-peer etcd-3-server etcd-3-metrics
+kubectl delete secret etcd-ca etcd-metric-ca
 kubectl delete pod etcd-1 etcd-2 etcd-3 etcd-certs
\ No newline at end of file
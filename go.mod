@@ -7,14 +7,17 @@ require (
 	github.com/google/certificate-transparency-go v1.0.21 // indirect
 	github.com/openshift/library-go v0.0.0-20190904120025-7d4acc018c61
 	github.com/operator-framework/operator-sdk v0.10.1-0.20190906161029-1cb0481ca946
+	github.com/prometheus/client_golang v0.9.3-0.20190127221311-3c4408c8b829
 	github.com/spf13/pflag v1.0.3
 	github.com/zmap/zlint v1.0.1 // indirect
+	golang.org/x/crypto v0.0.0-20190701094942-4def268fd1a4
 	k8s.io/api v0.0.0-20190612125737-db0771252981
 	k8s.io/apimachinery v0.0.0-20190612125636-6a5db36e93ad
 	k8s.io/client-go v11.0.0+incompatible
 	k8s.io/kube-openapi v0.0.0-20190603182131-db7b694dc208 // indirect
 	sigs.k8s.io/controller-runtime v0.1.12
 	sigs.k8s.io/controller-tools v0.1.10
+	sigs.k8s.io/yaml v1.1.0
 )
 
 // Pinned to kubernetes-1.13.4
@@ -0,0 +1,6 @@
+// Package etcd contains etcd API versions.
+//
+// This file ensures Go source parsers acknowledge the etcd package
+// and any child packages. It can be removed if any other Go source files are
+// added to this package.
+package etcd
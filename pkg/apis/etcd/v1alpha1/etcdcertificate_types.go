@@ -0,0 +1,108 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EtcdCertificateSpec defines the certificate signed into a secret
+// +k8s:openapi-gen=true
+type EtcdCertificateSpec struct {
+	// SecretName is the secret in the namespace of the EtcdCertificate the certificate, its key
+	// and the root of its CA are written to. The secret is created when it does not exist. An
+	// existing secret is only adopted when it has no controller and holds no data.
+	SecretName string `json:"secretName"`
+	// Hostnames are the DNS names and IP addresses of the certificate. The first one is the
+	// hostname of the default identity.
+	Hostnames []string `json:"hostnames"`
+	// Identity is the etcd identity, the common name of the built-in profiles. It defaults to
+	// system:<profile>:<first hostname>.
+	// +optional
+	Identity string `json:"identity,omitempty"`
+	// Profile is the signing profile of the certificate, e.g. "peer", "server" or "client".
+	Profile string `json:"profile"`
+	// KeyType is the algorithm of the private key, e.g. "ecdsa-p256". It defaults to the key
+	// algorithm of the profile.
+	// +optional
+	KeyType string `json:"keyType,omitempty"`
+	// RenewBefore is how long before its expiry the certificate is renewed. It defaults to a
+	// fifth of the certificate lifetime.
+	// +optional
+	RenewBefore *metav1.Duration `json:"renewBefore,omitempty"`
+}
+
+// EtcdCertificateConditionType is a condition of an EtcdCertificate.
+type EtcdCertificateConditionType string
+
+const (
+	// EtcdCertificateReady means the secret holds a valid certificate signed as specified.
+	EtcdCertificateReady EtcdCertificateConditionType = "Ready"
+)
+
+// EtcdCertificateCondition describes the state of an EtcdCertificate at a certain point.
+// +k8s:openapi-gen=true
+type EtcdCertificateCondition struct {
+	// Type of the condition.
+	Type EtcdCertificateConditionType `json:"type"`
+	// Status of the condition, one of True, False, Unknown.
+	Status corev1.ConditionStatus `json:"status"`
+	// LastTransitionTime is the last time the condition changed from one status to another.
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Reason is a brief CamelCase reason for the last transition.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// Message is a human readable description of the last transition.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// EtcdCertificateStatus defines the observed state of the certificate held by the secret
+// +k8s:openapi-gen=true
+type EtcdCertificateStatus struct {
+	// Conditions are the latest observations of the certificate.
+	// +optional
+	Conditions []EtcdCertificateCondition `json:"conditions,omitempty"`
+	// NotBefore is the start of the validity of the certificate.
+	// +optional
+	NotBefore *metav1.Time `json:"notBefore,omitempty"`
+	// NotAfter is the end of the validity of the certificate.
+	// +optional
+	NotAfter *metav1.Time `json:"notAfter,omitempty"`
+	// SerialNumber is the hexadecimal serial number of the certificate.
+	// +optional
+	SerialNumber string `json:"serialNumber,omitempty"`
+	// Issuer is the common name of the CA that signed the certificate.
+	// +optional
+	Issuer string `json:"issuer,omitempty"`
+	// LastRenewal is when the certificate was last signed.
+	// +optional
+	LastRenewal *metav1.Time `json:"lastRenewal,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// EtcdCertificate is a certificate signed by an etcd CA into a secret
+// +k8s:openapi-gen=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:path=etcdcertificates,scope=Namespaced
+type EtcdCertificate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   EtcdCertificateSpec   `json:"spec,omitempty"`
+	Status EtcdCertificateStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// EtcdCertificateList contains a list of EtcdCertificate
+type EtcdCertificateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []EtcdCertificate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&EtcdCertificate{}, &EtcdCertificateList{})
+}
@@ -0,0 +1,128 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EtcdCertSignerConfigName is the name of the EtcdCertSignerConfig singleton read by the operator.
+const EtcdCertSignerConfigName = "cluster"
+
+// MemberSecretPolicy describes what happens to the member secrets of an etcd pod once it is deleted.
+type MemberSecretPolicy string
+
+const (
+	// MemberSecretPolicyDeleteWithPod makes the pod the owner of its member secrets, so that they
+	// are garbage collected with it.
+	MemberSecretPolicyDeleteWithPod MemberSecretPolicy = "DeleteWithPod"
+	// MemberSecretPolicyDeleteWithStatefulSet makes the StatefulSet of the pod the owner of its
+	// member secrets, so that they survive the restarts of the pod. Pods without a StatefulSet own
+	// their member secrets. It is the default.
+	MemberSecretPolicyDeleteWithStatefulSet MemberSecretPolicy = "DeleteWithStatefulSet"
+	// MemberSecretPolicyRevoke holds the deletion of the pod with a finalizer to revoke the
+	// certificates of its member secrets and delete them once the member is permanently removed,
+	// i.e. the pod does not belong to a StatefulSet or was scaled down with it. The member secrets
+	// are owned like with DeleteWithStatefulSet.
+	MemberSecretPolicyRevoke MemberSecretPolicy = "Revoke"
+	// MemberSecretPolicyRetain keeps the member secrets of deleted pods, e.g. for static members.
+	MemberSecretPolicyRetain MemberSecretPolicy = "Retain"
+)
+
+// EtcdCertSignerConfigSpec defines the operator-wide settings of the signer. Fields left empty
+// use the built-in defaults.
+//
+// The signing profiles, the CA configurations and the DNS suffixes of member hostnames are not
+// settings: they are read from the etcd-cert-signer-config ConfigMap of the namespace of the
+// reconciled pod or EtcdCertificate, and of the operator namespace for CSRs. They are layered on
+// these settings: a profile of the ConfigMap replaces the built-in profile of the same name, and the
+// fields it leaves empty, like the fields of a CA configuration, default from CertificateValidity,
+// CASecretName and CAValidity. The ConfigMap cannot override a setting. As the CA secrets are shared
+// by every namespace, their configuration should be the same in every ConfigMap.
+// +k8s:openapi-gen=true
+type EtcdCertSignerConfigSpec struct {
+	// CASecretName is the secret holding the CA of the peer, server and client profiles. It
+	// defaults to etcd-ca.
+	// +optional
+	CASecretName string `json:"caSecretName,omitempty"`
+	// MetricsCASecretName is the secret holding the CA of the metrics profile. It defaults to
+	// etcd-metric-ca.
+	// +optional
+	MetricsCASecretName string `json:"metricsCASecretName,omitempty"`
+	// CASecretNamespace is the namespace of the CA secrets, their rotation status and the records
+	// of the certificates they issued. The CA bundles and CRLs are published in the namespaces of
+	// the etcd pods instead. It defaults to openshift-etcd. The operator must be restarted for a
+	// change to apply when it watches a single namespace.
+	// +optional
+	CASecretNamespace string `json:"caSecretNamespace,omitempty"`
+	// PodSelector selects the etcd member pods. It defaults to k8s-app=etcd.
+	// +optional
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+	// EtcdContainerName is the container of the etcd pods running etcd, whose restarts a CA
+	// rotation waits for. It defaults to etcd. Pods with a single container run etcd in it.
+	// +optional
+	EtcdContainerName string `json:"etcdContainerName,omitempty"`
+	// PeerSecretSuffix is appended to the pod name to name its peer secret. It defaults to -peer.
+	// +optional
+	PeerSecretSuffix string `json:"peerSecretSuffix,omitempty"`
+	// ServerSecretSuffix is appended to the pod name to name its server secret. It defaults to
+	// -server.
+	// +optional
+	ServerSecretSuffix string `json:"serverSecretSuffix,omitempty"`
+	// MetricsSecretSuffix is appended to the pod name to name its metrics secret. It defaults to
+	// -metrics.
+	// +optional
+	MetricsSecretSuffix string `json:"metricsSecretSuffix,omitempty"`
+	// CertificateValidity is the lifetime of the certificates signed with a profile that does not
+	// set one. It defaults to 3 years.
+	// +optional
+	CertificateValidity *metav1.Duration `json:"certificateValidity,omitempty"`
+	// CAValidity is the lifetime of the bootstrapped CAs that do not set one. It defaults to 10
+	// years.
+	// +optional
+	CAValidity *metav1.Duration `json:"caValidity,omitempty"`
+	// MemberSecretPolicy is what happens to the member secrets of deleted etcd pods, one of
+	// DeleteWithPod, DeleteWithStatefulSet, Revoke and Retain. It defaults to DeleteWithStatefulSet
+	// and can be overridden per pod with the auth.openshift.io/member-secret-policy annotation.
+	// +optional
+	MemberSecretPolicy MemberSecretPolicy `json:"memberSecretPolicy,omitempty"`
+	// CSRRequesterUsernames are the users, e.g. the service account of a kubecsr agent running
+	// outside of the etcd pods, whose CertificateSigningRequests for etcd member certificates are
+	// approved. The node running the member and the service account of its pod are always allowed.
+	// +optional
+	CSRRequesterUsernames []string `json:"csrRequesterUsernames,omitempty"`
+	// CSRRequesterGroups are the groups whose CertificateSigningRequests for etcd member
+	// certificates are approved.
+	// +optional
+	CSRRequesterGroups []string `json:"csrRequesterGroups,omitempty"`
+	// OCSPURL is the URL of the OCSP responder started with --ocsp-bind-address, e.g.
+	// http://etcd-cert-signer.openshift-etcd.svc:8889. It is advertised in the authority
+	// information access of the certificates signed by the CA of CASecretName, the only CA the
+	// responder answers for. No responder is advertised when empty.
+	// +optional
+	OCSPURL string `json:"ocspURL,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// EtcdCertSignerConfig holds the operator-wide settings of the signer. Only the one called
+// cluster is read.
+// +k8s:openapi-gen=true
+// +kubebuilder:resource:path=etcdcertsignerconfigs,scope=Cluster
+type EtcdCertSignerConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec EtcdCertSignerConfigSpec `json:"spec,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// EtcdCertSignerConfigList contains a list of EtcdCertSignerConfig
+type EtcdCertSignerConfigList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []EtcdCertSignerConfig `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&EtcdCertSignerConfig{}, &EtcdCertSignerConfigList{})
+}
@@ -0,0 +1,334 @@
+package etcdcertsigner
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EtcdCAValidity is the default lifetime of a bootstrapped CA.
+const EtcdCAValidity = 10 * 365 * 24 * time.Hour
+
+// CABundleKey is the ConfigMap key holding the PEM encoded CA bundle.
+const CABundleKey = "ca-bundle.crt"
+
+// CAChainKey is the optional CA secret key holding the PEM encoded chain from an intermediate CA
+// up to the root, whose key stays outside of the cluster.
+const CAChainKey = "ca-chain.crt"
+
+// CARootKey is the key of issued secrets holding the PEM encoded root of the issuing CA, or the
+// roots of both the old and the new CA while the CA is rotated.
+const CARootKey = "ca.crt"
+
+// caConfig describes how the CA held by a CA secret is generated and published.
+type caConfig struct {
+	// Bootstrap generates a self-signed CA when the CA secret does not exist.
+	Bootstrap bool `json:"bootstrap,omitempty"`
+	// Subject is the subject of the CA certificate, the common name is used as is.
+	Subject subjectTemplate `json:"subject,omitempty"`
+	// KeyAlgorithm is the algorithm of the CA private key, e.g. "ecdsa-p384".
+	KeyAlgorithm string `json:"keyAlgorithm,omitempty"`
+	// Validity is the lifetime of the CA certificate.
+	Validity metav1.Duration `json:"validity,omitempty"`
+	// BundleConfigMapName is the ConfigMap the CA certificate is published to.
+	BundleConfigMapName string `json:"bundleConfigMapName,omitempty"`
+	// CRLValidity is the time between the publication of a CRL and its next update.
+	CRLValidity metav1.Duration `json:"crlValidity,omitempty"`
+	// CRLConfigMapName is the ConfigMap the CRL of the CA is published to.
+	CRLConfigMapName string `json:"crlConfigMapName,omitempty"`
+	// GracePeriod is how long certificates that no longer chain to the CA, e.g. after the CA
+	// secret was replaced, are kept before being re-signed.
+	GracePeriod metav1.Duration `json:"gracePeriod,omitempty"`
+}
+
+// setDefaults fills the fields left empty in the configuration of the CA held by secretName from
+// the defaults and settings.
+func (c *caConfig) setDefaults(secretName string, settings *operatorSettings) {
+	if len(c.Subject.OrganizationalUnit) == 0 {
+		c.Subject.OrganizationalUnit = []string{"openshift"}
+	}
+	if c.Subject.CommonName == "" {
+		switch secretName {
+		case settings.CASecretName:
+			c.Subject.CommonName = "etcd-signer"
+		case settings.MetricsCASecretName:
+			c.Subject.CommonName = "etcd-metric-signer"
+		default:
+			c.Subject.CommonName = secretName
+		}
+	}
+	if c.KeyAlgorithm == "" {
+		c.KeyAlgorithm = KeyAlgorithmRSA2048
+	}
+	if c.Validity.Duration == 0 {
+		c.Validity = *settings.CAValidity
+	}
+	if c.BundleConfigMapName == "" {
+		c.BundleConfigMapName = secretName + "-bundle"
+	}
+	if c.CRLValidity.Duration == 0 {
+		c.CRLValidity.Duration = EtcdCRLValidity
+	}
+	if c.CRLConfigMapName == "" {
+		c.CRLConfigMapName = secretName + "-crl"
+	}
+}
+
+func (c *caConfig) validate() error {
+	if c.Validity.Duration < 0 {
+		return errors.NewBadRequest("CA validity must be positive")
+	}
+	if c.CRLValidity.Duration < 0 {
+		return errors.NewBadRequest("CRL validity must be positive")
+	}
+	if c.GracePeriod.Duration < 0 {
+		return errors.NewBadRequest("CA grace period must be positive")
+	}
+	return validateKeyAlgorithm(c.KeyAlgorithm)
+}
+
+// makeSelfSignedCA generates a key pair and a self-signed CA certificate as described by config.
+func makeSelfSignedCA(config *caConfig) (*bytes.Buffer, *bytes.Buffer, error) {
+	key, err := generateKey(config.KeyAlgorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	ski, err := subjectKeyID(key.Public())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization:       config.Subject.Organization,
+			OrganizationalUnit: config.Subject.OrganizationalUnit,
+			CommonName:         config.Subject.CommonName,
+		},
+		NotBefore:             time.Now().Add(-1 * time.Second),
+		NotAfter:              time.Now().Add(config.Validity.Duration),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SubjectKeyId:          ski,
+		AuthorityKeyId:        ski,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certBytes := &bytes.Buffer{}
+	if err := pem.Encode(certBytes, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+		return nil, nil, err
+	}
+	keyBytes, err := encodePrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certBytes, keyBytes, nil
+}
+
+// getCASecret returns the CA secret called name together with the secret holding the CA that
+// currently signs certificates for it, which differs while the CA is being rotated. When the
+// secret does not exist and the CA is configured to be bootstrapped, a self-signed CA is
+// generated and stored in a new secret first. The certificate of a bootstrapped CA is published
+// to its bundle ConfigMap in the member namespaces. The returned bool reports whether a CA
+// rotation is in progress.
+func (r *EtcdCertSigner) getCASecret(name string, namespace string, config *signerConfig) (*corev1.Secret, bool, error) {
+	caConfig, bootstrap := config.getCAConfig(name)
+
+	secret, err := r.getSecret(name, namespace)
+	if err != nil {
+		if !errors.IsNotFound(err) || !bootstrap {
+			return nil, false, err
+		}
+		log.Info("Bootstrapping CA", "Secret.Namespace", namespace, "Secret.Name", name)
+		secret, err = r.createCASecret(name, namespace, &caConfig)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	signingCA, rotating, err := r.reconcileCARotation(secret, &caConfig, config)
+	if err != nil || rotating {
+		return signingCA, rotating, err
+	}
+	if bootstrap {
+		caCert, err := parseCertificate(secret.Data["tls.crt"])
+		if err != nil {
+			return nil, false, err
+		}
+		if err := r.ensureCABundle(caConfig.BundleConfigMapName, config.Settings, caCert); err != nil {
+			return nil, false, err
+		}
+	}
+	return signingCA, false, nil
+}
+
+func (r *EtcdCertSigner) createCASecret(name string, namespace string, config *caConfig) (*corev1.Secret, error) {
+	cert, key, err := makeSelfSignedCA(config)
+	if err != nil {
+		return nil, err
+	}
+	secret := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Data: map[string][]byte{
+			"tls.crt": cert.Bytes(),
+			"tls.key": key.Bytes(),
+		},
+		Type: corev1.SecretTypeTLS,
+	}
+	if err := r.client.Create(context.Background(), secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// ensureCABundle publishes caCerts to the ConfigMap called name in each member namespace, where
+// the etcd pods can mount it.
+func (r *EtcdCertSigner) ensureCABundle(name string, settings *operatorSettings, caCerts ...*x509.Certificate) error {
+	bundle := &bytes.Buffer{}
+	for _, caCert := range caCerts {
+		if err := pem.Encode(bundle, &pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}); err != nil {
+			return err
+		}
+	}
+
+	namespaces, err := r.getMemberNamespaces(settings)
+	if err != nil {
+		return err
+	}
+	for _, namespace := range namespaces {
+		cm, err := r.getConfigMap(name, namespace)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		if cm != nil && cm.Data[CABundleKey] == bundle.String() {
+			continue
+		}
+		if err := r.publishConfigMapKey(cm, name, namespace, CABundleKey, bundle.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publishConfigMapKey sets key to value in cm, the ConfigMap called name in namespace, which is
+// created when cm is nil.
+func (r *EtcdCertSigner) publishConfigMapKey(cm *corev1.ConfigMap, name string, namespace string, key string, value string) error {
+	if cm == nil {
+		return r.client.Create(context.Background(), &corev1.ConfigMap{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: corev1.SchemeGroupVersion.String(),
+				Kind:       "ConfigMap",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+			},
+			Data: map[string]string{
+				key: value,
+			},
+		})
+	}
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data[key] = value
+	return r.client.Update(context.Background(), cm)
+}
+
+// loadSigningCASecret loads the CA held by secret together with its chain, if any.
+func loadSigningCASecret(secret *corev1.Secret) (*signingCA, error) {
+	if err := ensureCASecret(secret); err != nil {
+		return nil, err
+	}
+	ca, err := loadSigningCA(secret.Data["tls.crt"], secret.Data["tls.key"])
+	if err != nil {
+		return nil, err
+	}
+	if chainPEM, ok := secret.Data[CAChainKey]; ok && len(chainPEM) > 0 {
+		if err := ca.setChain(chainPEM); err != nil {
+			return nil, err
+		}
+	}
+	return ca, nil
+}
+
+// getCARootPEM returns the PEM encoded root of the CA held by secret.
+func getCARootPEM(secret *corev1.Secret) ([]byte, error) {
+	ca, err := loadSigningCASecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.root().Raw}), nil
+}
+
+// validateKeyPair checks that secret holds a parseable certificate and private key, and that the
+// key matches the certificate.
+func validateKeyPair(secret *corev1.Secret) error {
+	certPEM, ok := secret.Data["tls.crt"]
+	if !ok {
+		return errors.NewBadRequest("Certificate not found")
+	}
+	keyPEM, ok := secret.Data["tls.key"]
+	if !ok {
+		return errors.NewBadRequest("Private key not found")
+	}
+	cert, err := parseCertificate(certPEM)
+	if err != nil {
+		return err
+	}
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return err
+	}
+	return checkKeyMatches(cert, key)
+}
+
+// verifyIssuedBy checks that the certificate held by secret is valid and chains to the root of
+// the CA held by caSecret through the CA certificate.
+func verifyIssuedBy(secret *corev1.Secret, caSecret *corev1.Secret) error {
+	cert, err := parseCertificate(secret.Data["tls.crt"])
+	if err != nil {
+		return err
+	}
+	ca, err := loadSigningCASecret(caSecret)
+	if err != nil {
+		return err
+	}
+	return ca.verify(cert)
+}
+
+// isSignedBy reports whether the certificate held by secret was signed by the CA held by caSecret.
+func isSignedBy(secret *corev1.Secret, caSecret *corev1.Secret) bool {
+	cert, err := parseCertificate(secret.Data["tls.crt"])
+	if err != nil {
+		return false
+	}
+	caCert, err := parseCertificate(caSecret.Data["tls.crt"])
+	if err != nil {
+		return false
+	}
+	return cert.CheckSignatureFrom(caCert) == nil
+}
@@ -0,0 +1,157 @@
+package etcdcertsigner
+
+import (
+	"strings"
+
+	"github.com/operator-framework/operator-sdk/pkg/k8sutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// signerConfigMapName is the ConfigMap holding the operator configuration.
+	signerConfigMapName = "etcd-cert-signer-config"
+	// signerConfigProfilesKey holds the signing profiles, keyed by profile name, in YAML or JSON.
+	signerConfigProfilesKey = "profiles.yaml"
+	// signerConfigCAKey holds the CA configurations, keyed by CA secret name, in YAML or JSON.
+	signerConfigCAKey = "certificateAuthorities.yaml"
+	// signerConfigDNSSuffixesKey holds the DNS suffixes appended to the pod names in the hostnames
+	// of member certificates, as a list in YAML or JSON.
+	signerConfigDNSSuffixesKey = "dnsSuffixes.yaml"
+)
+
+// signerConfig is the operator configuration used while reconciling.
+type signerConfig struct {
+	// Settings are the operator-wide settings the configuration was defaulted from.
+	Settings               *operatorSettings
+	Profiles               map[string]signingProfile
+	CertificateAuthorities map[string]caConfig
+	// DNSSuffixes are appended to the pod name and hostname to derive the hostnames of members.
+	DNSSuffixes []string
+}
+
+func defaultSignerConfig() *signerConfig {
+	return newSignerConfig(defaultOperatorSettings())
+}
+
+// newSignerConfig returns the configuration used when namespaces do not have an operator ConfigMap.
+func newSignerConfig(settings *operatorSettings) *signerConfig {
+	return &signerConfig{
+		Settings:               settings,
+		Profiles:               defaultProfiles(settings),
+		CertificateAuthorities: map[string]caConfig{},
+	}
+}
+
+// getSignerNamespace returns the namespace watched by the operator, which holds the etcd pods and
+// the CA secrets used outside of pod reconciles. It defaults to the etcd namespace when the
+// operator watches all namespaces.
+func getSignerNamespace() (string, error) {
+	namespace, err := k8sutil.GetWatchNamespace()
+	if err != nil {
+		return "", err
+	}
+	if namespace == "" {
+		namespace = etcdCASecretNamespace
+	}
+	return namespace, nil
+}
+
+// getSignerConfig returns the default configuration of the operator settings overlaid with the
+// operator ConfigMap in namespace. A configured profile replaces the default profile of the same
+// name. The ConfigMap only holds profiles, CAs and DNS suffixes, and defaults them from the
+// settings of the EtcdCertSignerConfig, which always take precedence.
+func (r *EtcdCertSigner) getSignerConfig(namespace string) (*signerConfig, error) {
+	settings, err := r.getOperatorSettings()
+	if err != nil {
+		return nil, err
+	}
+	config := newSignerConfig(settings)
+	cm, err := r.getConfigMap(signerConfigMapName, namespace)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return config, nil
+		}
+		return nil, err
+	}
+	if err := config.loadConfigMap(cm); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (c *signerConfig) loadConfigMap(cm *corev1.ConfigMap) error {
+	if data, ok := cm.Data[signerConfigProfilesKey]; ok {
+		profiles := map[string]signingProfile{}
+		if err := yaml.Unmarshal([]byte(data), &profiles); err != nil {
+			return errors.NewBadRequest("Unable to parse signing profiles: " + err.Error())
+		}
+		for name, profile := range profiles {
+			profile.setDefaults(c.Settings)
+			if err := profile.validate(); err != nil {
+				return errors.NewBadRequest("Invalid signing profile " + name + ": " + err.Error())
+			}
+			c.Profiles[name] = profile
+		}
+	}
+
+	if data, ok := cm.Data[signerConfigCAKey]; ok {
+		cas := map[string]caConfig{}
+		if err := yaml.Unmarshal([]byte(data), &cas); err != nil {
+			return errors.NewBadRequest("Unable to parse certificate authorities: " + err.Error())
+		}
+		for name, ca := range cas {
+			ca.setDefaults(name, c.Settings)
+			if err := ca.validate(); err != nil {
+				return errors.NewBadRequest("Invalid certificate authority " + name + ": " + err.Error())
+			}
+			c.CertificateAuthorities[name] = ca
+		}
+	}
+
+	if data, ok := cm.Data[signerConfigDNSSuffixesKey]; ok {
+		var suffixes []string
+		if err := yaml.Unmarshal([]byte(data), &suffixes); err != nil {
+			return errors.NewBadRequest("Unable to parse DNS suffixes: " + err.Error())
+		}
+		for _, suffix := range suffixes {
+			suffix = strings.Trim(suffix, ".")
+			if suffix == "" || strings.ContainsAny(suffix, " *,") {
+				return errors.NewBadRequest("Invalid DNS suffix " + suffix)
+			}
+			c.DNSSuffixes = append(c.DNSSuffixes, suffix)
+		}
+	}
+	return nil
+}
+
+// getCAConfig returns the configuration of the CA held by the secret called name, or the
+// defaults when it is not configured. The returned bool reports whether the CA is bootstrapped.
+func (c *signerConfig) getCAConfig(name string) (caConfig, bool) {
+	config, ok := c.CertificateAuthorities[name]
+	if !ok {
+		config.setDefaults(name, c.Settings)
+	}
+	return config, config.Bootstrap
+}
+
+// usesCASecret returns whether a signing profile signs with the CA held by the secret called name,
+// or with the CA replacing it during a rotation.
+func (c *signerConfig) usesCASecret(name string) bool {
+	for _, profile := range c.Profiles {
+		if profile.CASecretName == name || getNextCASecretName(profile.CASecretName) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// getProfile returns the signing profile called name.
+func (c *signerConfig) getProfile(name string) (*signingProfile, error) {
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return nil, errors.NewBadRequest("Signing profile " + name + " not found")
+	}
+	return &profile, nil
+}
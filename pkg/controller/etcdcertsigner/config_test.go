@@ -0,0 +1,122 @@
+package etcdcertsigner
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestEtcdCertSigner_getSignerConfig(t *testing.T) {
+	configMap := func(profiles string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			TypeMeta: v1.TypeMeta{APIVersion: corev1.SchemeGroupVersion.String(),
+				Kind: "ConfigMap"},
+			ObjectMeta: v1.ObjectMeta{
+				Name:      signerConfigMapName,
+				Namespace: "etcd-namespace",
+			},
+			Data: map[string]string{
+				signerConfigProfilesKey: profiles,
+			},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		objects     []runtime.Object
+		wantProfile string
+		want        signingProfile
+		wantErr     bool
+	}{
+		{
+			name:        "No operator config",
+			wantProfile: ServerProfile,
+			want:        defaultProfiles(defaultOperatorSettings())[ServerProfile],
+		},
+		{
+			name: "Configured profile replaces the default",
+			objects: []runtime.Object{configMap(`
+server:
+  validity: 720h
+  extKeyUsages: ["server auth"]
+  subject:
+    organization: ["system:etcd-servers"]
+`)},
+			wantProfile: ServerProfile,
+			want: signingProfile{
+				Validity:     v1.Duration{Duration: 720 * time.Hour},
+				ExtKeyUsages: []string{"server auth"},
+				Subject: subjectTemplate{
+					Organization: []string{"system:etcd-servers"},
+					CommonName:   "{{.Identity}}",
+				},
+				CASecretName: etcdCASecretName,
+				KeyAlgorithm: KeyAlgorithmRSA2048,
+			},
+		},
+		{
+			name: "Configured profile is added",
+			objects: []runtime.Object{configMap(`
+apiserver:
+  extKeyUsages: ["client auth"]
+  keyAlgorithm: ecdsa-p256
+  caSecretName: etcd-client-ca
+`)},
+			wantProfile: "apiserver",
+			want: signingProfile{
+				Validity:     v1.Duration{Duration: EtcdCertValidity},
+				ExtKeyUsages: []string{"client auth"},
+				Subject: subjectTemplate{
+					CommonName: "{{.Identity}}",
+				},
+				CASecretName: "etcd-client-ca",
+				KeyAlgorithm: KeyAlgorithmECDSAP256,
+			},
+		},
+		{
+			name: "Invalid profile",
+			objects: []runtime.Object{configMap(`
+server:
+  keyUsages: ["teleport"]
+`)},
+			wantErr: true,
+		},
+		{
+			name: "Unsupported key algorithm",
+			objects: []runtime.Object{configMap(`
+server:
+  keyAlgorithm: dsa-1024
+`)},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := EtcdCertSigner{
+				client: fake.NewFakeClient(tt.objects...),
+				scheme: nil,
+			}
+			config, err := r.getSignerConfig("etcd-namespace")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getSignerConfig() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			got, err := config.getProfile(tt.wantProfile)
+			if err != nil {
+				t.Errorf("getProfile() error = %v", err)
+				return
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("getProfile() got = %v, want %v", *got, tt.want)
+			}
+		})
+	}
+}
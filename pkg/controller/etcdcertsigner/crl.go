@@ -0,0 +1,173 @@
+package etcdcertsigner
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// CertificateRevokeAnnotation revokes the certificate held by the annotated secret when set to
+// "true". A new certificate is signed into the secret and the annotation is removed.
+const CertificateRevokeAnnotation = "auth.openshift.io/certificate-revoke"
+
+// EtcdCRLValidity is the default time between the publication of a CRL and its next update.
+const EtcdCRLValidity = 7 * 24 * time.Hour
+
+const (
+	// CRLKey is the ConfigMap key holding the PEM encoded CRL, for etcd --peer-crl-file and --client-crl-file.
+	CRLKey = "ca.crl"
+	// revokedCertificatesKey is the ConfigMap key holding the revoked certificates of a CA in YAML.
+	revokedCertificatesKey = "revoked.yaml"
+)
+
+// getRevokedCertificatesName returns the ConfigMap recording the certificates revoked for the CA held by caSecretName.
+func getRevokedCertificatesName(caSecretName string) string {
+	return caSecretName + "-revoked"
+}
+
+func revocationRequested(secret *corev1.Secret) bool {
+	return secret.GetAnnotations()[CertificateRevokeAnnotation] == "true"
+}
+
+// revokeCertificate records the certificate held by secret as revoked by the CA held by
+// caSecretName in caNamespace. The certificate is dropped from secret so that a new one is signed, which also
+// persists the removal of the revocation request.
+func (r *EtcdCertSigner) revokeCertificate(caSecretName string, caNamespace string, secret *corev1.Secret) error {
+	delete(secret.Annotations, CertificateRevokeAnnotation)
+	certPEM, ok := secret.Data["tls.crt"]
+	if !ok {
+		return nil
+	}
+	defer delete(secret.Data, "tls.crt")
+	cert, err := parseCertificate(certPEM)
+	if err != nil {
+		// Nothing trusted can be holding an unparseable certificate
+		return nil
+	}
+
+	log.Info("Revoking certificate", "Secret.Namespace", secret.Namespace, "Secret.Name", secret.Name, "SerialNumber", cert.SerialNumber.Text(16))
+	r.recordEvent(secret, corev1.EventTypeNormal, "CertificateRevoked", "Revoked certificate "+cert.SerialNumber.Text(16)+" of secret "+secret.Name)
+	now := metav1.Now()
+	return r.addCertificateRecord(getRevokedCertificatesName(caSecretName), caNamespace, revokedCertificatesKey, certificateRecord{
+		SerialNumber:   cert.SerialNumber.Text(16),
+		NotAfter:       metav1.NewTime(cert.NotAfter),
+		RevocationTime: &now,
+	})
+}
+
+// ensureCRL publishes a CRL of the certificates revoked for the CA held by caSecretName, signed by
+// the CA held by signingCASecret, in each member namespace. The revocations are read from the CA
+// namespace. The CRL is regenerated when the revoked certificates or the signing CA change, and
+// once half of its validity has passed. It returns when the CRL is due to be regenerated.
+func (r *EtcdCertSigner) ensureCRL(signingCASecret *corev1.Secret, caSecretName string, config *signerConfig) (time.Time, error) {
+	caConfig, _ := config.getCAConfig(caSecretName)
+
+	revoked, err := r.getCertificateRecords(getRevokedCertificatesName(caSecretName), signingCASecret.Namespace, revokedCertificatesKey)
+	if err != nil {
+		return time.Time{}, err
+	}
+	ca, err := loadSigningCASecret(signingCASecret)
+	if err != nil {
+		return time.Time{}, err
+	}
+	namespaces, err := r.getMemberNamespaces(config.Settings)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	now := time.Now()
+	refresh := now.Add(caConfig.CRLValidity.Duration / 2)
+	var crlPEM []byte
+	for _, namespace := range namespaces {
+		crlCM, err := r.getConfigMap(caConfig.CRLConfigMapName, namespace)
+		if err != nil && !errors.IsNotFound(err) {
+			return time.Time{}, err
+		}
+		if crlCM != nil {
+			if crlRefresh, ok := crlUpToDate(crlCM.Data[CRLKey], ca.cert, revoked); ok {
+				if crlRefresh.Before(refresh) {
+					refresh = crlRefresh
+				}
+				continue
+			}
+		}
+
+		if crlPEM == nil {
+			crlPEM, err = ca.createCRL(revoked, now, caConfig.CRLValidity.Duration)
+			if err != nil {
+				return time.Time{}, err
+			}
+		}
+		log.Info("Publishing CRL", "ConfigMap.Namespace", namespace, "ConfigMap.Name", caConfig.CRLConfigMapName, "Revoked", len(revoked))
+		if err := r.publishConfigMapKey(crlCM, caConfig.CRLConfigMapName, namespace, CRLKey, string(crlPEM)); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return refresh, nil
+}
+
+// crlUpToDate reports whether crlPEM was signed by caCert, lists exactly the unexpired revoked
+// certificates and is less than halfway to its next update, which is when it should be refreshed.
+func crlUpToDate(crlPEM string, caCert *x509.Certificate, revoked []certificateRecord) (time.Time, bool) {
+	block, _ := pem.Decode([]byte(crlPEM))
+	if block == nil || block.Type != "X509 CRL" {
+		return time.Time{}, false
+	}
+	crl, err := x509.ParseCRL(block.Bytes)
+	if err != nil || caCert.CheckCRLSignature(crl) != nil {
+		return time.Time{}, false
+	}
+	thisUpdate, nextUpdate := crl.TBSCertList.ThisUpdate, crl.TBSCertList.NextUpdate
+	refresh := thisUpdate.Add(nextUpdate.Sub(thisUpdate) / 2)
+	if !time.Now().Before(refresh) {
+		return time.Time{}, false
+	}
+
+	listed := sets.NewString()
+	for _, c := range crl.TBSCertList.RevokedCertificates {
+		listed.Insert(c.SerialNumber.Text(16))
+	}
+	wanted := sets.NewString()
+	for _, c := range revoked {
+		if c.NotAfter.Time.After(time.Now()) {
+			wanted.Insert(c.SerialNumber)
+		}
+	}
+	return refresh, listed.Equal(wanted)
+}
+
+// createCRL returns a PEM encoded CRL of the unexpired revoked certificates valid for validity from now.
+func (ca *signingCA) createCRL(revoked []certificateRecord, now time.Time, validity time.Duration) ([]byte, error) {
+	var entries []pkix.RevokedCertificate
+	for _, c := range revoked {
+		if !c.NotAfter.Time.After(now) {
+			continue
+		}
+		serial, ok := new(big.Int).SetString(c.SerialNumber, 16)
+		if !ok {
+			return nil, errors.NewBadRequest("Invalid revoked serial number " + c.SerialNumber)
+		}
+		revocationTime := now
+		if c.RevocationTime != nil {
+			revocationTime = c.RevocationTime.Time
+		}
+		entries = append(entries, pkix.RevokedCertificate{
+			SerialNumber:   serial,
+			RevocationTime: revocationTime.UTC(),
+		})
+	}
+	// CreateCRL does not require the CRL sign key usage, which CAs created by the installer lack
+	der, err := ca.cert.CreateCRL(rand.Reader, ca.key, entries, now, now.Add(validity))
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), nil
+}
@@ -0,0 +1,160 @@
+package etcdcertsigner
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestEtcdCertSigner_revokeCertificate(t *testing.T) {
+	const namespace = "etcd-namespace"
+	caConfig, _ := defaultSignerConfig().getCAConfig(etcdCASecretName)
+	// The revocations are recorded next to the CA and the CRL is published next to the members
+	caSecret := newTestCASecret(t, etcdCASecretName, etcdCASecretNamespace)
+	peerSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "etcd-0-peer",
+			Namespace: namespace,
+			Annotations: map[string]string{
+				CertificateHostnames:    "etcd-0.etcd.test",
+				CertificateEtcdIdentity: "system:peer:etcd-0",
+			},
+		},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "etcd-0",
+			Namespace: namespace,
+			Labels:    map[string]string{"k8s-app": "etcd"},
+		},
+	}
+	r := EtcdCertSigner{
+		client: fake.NewFakeClient(caSecret, peerSecret, pod),
+		scheme: nil,
+	}
+	config := defaultSignerConfig()
+	peerProfile := defaultProfiles(defaultOperatorSettings())[PeerProfile]
+
+	revokedSerials := func() map[string]bool {
+		t.Helper()
+		if _, err := r.ensureCRL(caSecret, etcdCASecretName, config); err != nil {
+			t.Fatalf("ensureCRL() error = %v", err)
+		}
+		cm, err := r.getConfigMap(caConfig.CRLConfigMapName, namespace)
+		if err != nil {
+			t.Fatalf("CRL not published: %v", err)
+		}
+		block, _ := pem.Decode([]byte(cm.Data[CRLKey]))
+		if block == nil {
+			t.Fatalf("CRL not PEM encoded")
+		}
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			t.Fatalf("CRL unparseable: %v", err)
+		}
+		if err := crl.CheckSignatureFrom(parseTestCert(t, caSecret.Data["tls.crt"])); err != nil {
+			t.Errorf("CRL not signed by the CA: %v", err)
+		}
+		if got := crl.NextUpdate.Sub(crl.ThisUpdate); got != EtcdCRLValidity {
+			t.Errorf("CRL validity = %v, want %v", got, EtcdCRLValidity)
+		}
+		serials := map[string]bool{}
+		for _, c := range crl.RevokedCertificateEntries {
+			serials[c.SerialNumber.Text(16)] = true
+		}
+		return serials
+	}
+
+	if got := revokedSerials(); len(got) != 0 {
+		t.Errorf("CRL lists %v before any revocation", got)
+	}
+
+	if _, err := r.ensureCertificate(caSecret, peerSecret, &certificateRequest{profile: &peerProfile, hostnames: []string{"etcd-0.etcd.test"}, identity: "system:peer:etcd-0"}); err != nil {
+		t.Fatalf("ensureCertificate() error = %v", err)
+	}
+	leakedPEM := peerSecret.Data["tls.crt"]
+	leaked := parseTestCert(t, leakedPEM)
+
+	peerSecret.Annotations[CertificateRevokeAnnotation] = "true"
+	if !revocationRequested(peerSecret) {
+		t.Fatalf("revocationRequested() = false")
+	}
+	if err := r.revokeCertificate(etcdCASecretName, etcdCASecretNamespace, peerSecret); err != nil {
+		t.Fatalf("revokeCertificate() error = %v", err)
+	}
+	// Revoking twice records the serial once
+	if err := r.revokeCertificate(etcdCASecretName, etcdCASecretNamespace, &corev1.Secret{
+		ObjectMeta: peerSecret.ObjectMeta,
+		Data:       map[string][]byte{"tls.crt": leakedPEM},
+	}); err != nil {
+		t.Fatalf("revokeCertificate() error = %v", err)
+	}
+	if _, err := r.ensureCertificate(caSecret, peerSecret, &certificateRequest{profile: &peerProfile, hostnames: []string{"etcd-0.etcd.test"}, identity: "system:peer:etcd-0"}); err != nil {
+		t.Fatalf("ensureCertificate() error = %v", err)
+	}
+
+	stored, _ := r.getSecret(peerSecret.Name, namespace)
+	if revocationRequested(stored) {
+		t.Errorf("revocation request not removed from the secret")
+	}
+	reissued := parseTestCert(t, stored.Data["tls.crt"])
+	if reissued.SerialNumber.Cmp(leaked.SerialNumber) == 0 {
+		t.Errorf("revoked certificate not replaced")
+	}
+
+	got := revokedSerials()
+	if len(got) != 1 || !got[leaked.SerialNumber.Text(16)] {
+		t.Errorf("CRL lists %v, want only %v", got, leaked.SerialNumber.Text(16))
+	}
+	if _, err := r.getConfigMap(getRevokedCertificatesName(etcdCASecretName), etcdCASecretNamespace); err != nil {
+		t.Errorf("revoked certificates not recorded in the CA namespace: %v", err)
+	}
+	if _, err := r.getConfigMap(caConfig.CRLConfigMapName, etcdCASecretNamespace); !errors.IsNotFound(err) {
+		t.Errorf("CRL published in the CA namespace, error = %v", err)
+	}
+}
+
+func Test_signingCA_createCRL(t *testing.T) {
+	ca := newTestCA(t, true)
+	now := time.Now()
+	revoked := []certificateRecord{
+		{SerialNumber: "1f", NotAfter: metav1.NewTime(now.Add(time.Hour))},
+		{SerialNumber: "2f", NotAfter: metav1.NewTime(now.Add(-time.Hour))},
+	}
+	crlPEM, err := ca.createCRL(revoked, now, time.Hour)
+	if err != nil {
+		t.Fatalf("createCRL() error = %v", err)
+	}
+	if _, ok := crlUpToDate(string(crlPEM), ca.cert, revoked); !ok {
+		t.Errorf("crlUpToDate() = false for a fresh CRL")
+	}
+	if _, ok := crlUpToDate(string(crlPEM), ca.cert, revoked[:0]); ok {
+		t.Errorf("crlUpToDate() = true when the revoked certificates changed")
+	}
+	if _, ok := crlUpToDate(string(crlPEM), newTestCA(t, true).cert, revoked); ok {
+		t.Errorf("crlUpToDate() = true for another CA")
+	}
+
+	stale, err := ca.createCRL(revoked, now.Add(-time.Hour), time.Hour)
+	if err != nil {
+		t.Fatalf("createCRL() error = %v", err)
+	}
+	if _, ok := crlUpToDate(string(stale), ca.cert, revoked); ok {
+		t.Errorf("crlUpToDate() = true for a CRL past half of its validity")
+	}
+}
+
+func parseTestCert(t *testing.T, certPEM []byte) *x509.Certificate {
+	t.Helper()
+	cert, err := parseCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
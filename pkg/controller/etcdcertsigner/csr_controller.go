@@ -0,0 +1,334 @@
+package etcdcertsigner
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"time"
+
+	certificatesv1beta1 "k8s.io/api/certificates/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes"
+	certificatesclient "k8s.io/client-go/kubernetes/typed/certificates/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// csrProfile describes the CSRs requested by the kubecsr agent for one kind of etcd certificate.
+type csrProfile struct {
+	// profile is the signing profile used for the CSR.
+	profile string
+	// commonNamePrefix prefixes the etcd member hostname in the common name of the CSR.
+	commonNamePrefix string
+}
+
+// csrMemberWaitPeriod is how long a CSR that matches no etcd member is retried before it is denied,
+// as the pod or member secret it was requested for may not be cached yet.
+const csrMemberWaitPeriod = 5 * time.Minute
+
+// podNameExtraKey is the extra user info holding the name of the pod a service account token is bound to.
+const podNameExtraKey = "authentication.kubernetes.io/pod-name"
+
+// csrProfiles maps the subject organization of etcd CSRs to their profile.
+var csrProfiles = map[string]csrProfile{
+	"system:etcd-peers":   {profile: PeerProfile, commonNamePrefix: "system:etcd-peer:"},
+	"system:etcd-servers": {profile: ServerProfile, commonNamePrefix: "system:etcd-server:"},
+	"system:etcd-metrics": {profile: MetricsProfile, commonNamePrefix: "system:etcd-metric:"},
+}
+
+// AddCSRSigner creates a new CSRSigner Controller and adds it to the Manager. The Manager will set fields on the
+// Controller and Start it when the Manager is Started.
+func AddCSRSigner(mgr manager.Manager) error {
+	r, err := newCSRSigner(mgr)
+	if err != nil {
+		return err
+	}
+	return addCSRSigner(mgr, r)
+}
+
+// newCSRSigner returns a new reconcile.Reconciler signing the etcd CSRs
+func newCSRSigner(mgr manager.Manager) (reconcile.Reconciler, error) {
+	// CSR approval is only exposed by the typed client
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return nil, err
+	}
+	namespace, err := getSignerNamespace()
+	if err != nil {
+		return nil, err
+	}
+	return &CSRSigner{
+		signer:     &EtcdCertSigner{client: mgr.GetClient(), scheme: mgr.GetScheme()},
+		certClient: clientset.CertificatesV1beta1(),
+		namespace:  namespace,
+	}, nil
+}
+
+// addCSRSigner adds a new Controller to mgr with r as the reconcile.Reconciler
+func addCSRSigner(mgr manager.Manager, r reconcile.Reconciler) error {
+	c, err := controller.New("etcd-csr-signer-controller", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to primary resource CertificateSigningRequest
+	return c.Watch(&source.Kind{Type: &certificatesv1beta1.CertificateSigningRequest{}}, &handler.EnqueueRequestForObject{})
+}
+
+// blank assignment to verify that CSRSigner implements reconcile.Reconciler
+var _ reconcile.Reconciler = &CSRSigner{}
+
+// CSRSigner approves and signs the CertificateSigningRequests of etcd members
+type CSRSigner struct {
+	// signer reads the configuration, etcd pods and CAs in namespace
+	signer     *EtcdCertSigner
+	certClient certificatesclient.CertificatesV1beta1Interface
+	// namespace holds the etcd pods and their secrets
+	namespace string
+}
+
+// Reconcile approves CertificateSigningRequests for etcd members that pass the policy, denies the
+// other etcd CSRs and writes the certificate signed by the etcd CA to the status of the CSRs that
+// pass the policy. CSRs approved by someone else are only signed when they pass the policy too.
+func (r *CSRSigner) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	reqLogger := log.WithValues("Request.Name", request.Name)
+	reqLogger.Info("Reconciling CertificateSigningRequest")
+
+	csr := &certificatesv1beta1.CertificateSigningRequest{}
+	err := r.signer.client.Get(context.TODO(), request.NamespacedName, csr)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		reqLogger.Error(err, "Skip reconcile: Error getting CSR", "CSR.Name", request.Name)
+		return reconcile.Result{}, err
+	}
+
+	if len(csr.Status.Certificate) > 0 || hasCSRCondition(csr, certificatesv1beta1.CertificateDenied) {
+		return reconcile.Result{}, nil
+	}
+
+	req, err := parseCSR(csr.Spec.Request)
+	if err != nil {
+		reqLogger.Info("Skip reconcile: Unable to parse CSR", "CSR.Name", csr.Name, "error", err.Error())
+		return reconcile.Result{}, nil
+	}
+	p, ok := getCSRProfile(req)
+	if !ok {
+		// Not an etcd CSR
+		return reconcile.Result{}, nil
+	}
+
+	approved := hasCSRCondition(csr, certificatesv1beta1.CertificateApproved)
+	if err := r.checkCSRPolicy(csr, req, p); err != nil {
+		if errors.IsNotFound(err) && time.Since(csr.CreationTimestamp.Time) < csrMemberWaitPeriod {
+			reqLogger.Info("Waiting for the etcd member of CSR", "CSR.Name", csr.Name, "reason", err.Error())
+			return reconcile.Result{Requeue: true}, nil
+		}
+		if !errors.IsBadRequest(err) && !errors.IsNotFound(err) {
+			reqLogger.Error(err, "Unable to check CSR policy", "CSR.Name", csr.Name)
+			return reconcile.Result{}, err
+		}
+		if approved {
+			// An approved CSR can no longer be denied, it is left unsigned
+			reqLogger.Info("Skip reconcile: Approved CSR does not pass the etcd member policy", "CSR.Name", csr.Name, "reason", err.Error())
+			return reconcile.Result{}, nil
+		}
+		reqLogger.Info("Denying CSR", "CSR.Name", csr.Name, "reason", err.Error())
+		setCSRCondition(csr, certificatesv1beta1.CertificateDenied, "EtcdMemberPolicyFailed", err.Error())
+		_, err := r.certClient.CertificateSigningRequests().UpdateApproval(csr)
+		return reconcile.Result{}, err
+	}
+	if !approved {
+		setCSRCondition(csr, certificatesv1beta1.CertificateApproved, "EtcdMemberApproved", "Auto approving etcd member certificate")
+		csr, err = r.certClient.CertificateSigningRequests().UpdateApproval(csr)
+		if err != nil {
+			reqLogger.Error(err, "Unable to approve CSR", "CSR.Name", request.Name)
+			return reconcile.Result{}, err
+		}
+	}
+
+	cert, err := r.signCSR(req, p)
+	if err != nil {
+		reqLogger.Error(err, "Unable to sign CSR", "CSR.Name", csr.Name, "Profile", p.profile)
+		return reconcile.Result{}, err
+	}
+	csr.Status.Certificate = cert
+	if _, err := r.certClient.CertificateSigningRequests().UpdateStatus(csr); err != nil {
+		reqLogger.Error(err, "Unable to update CSR status", "CSR.Name", csr.Name)
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{}, nil
+}
+
+// checkCSRPolicy returns a BadRequest error describing why req, parsed from csr, may not be
+// signed unless its common name and all its SANs belong to a single etcd member and csr was
+// requested by an allowed requester or by that member. A NotFound error is returned when no etcd
+// member matches, which may only be until the member is cached.
+func (r *CSRSigner) checkCSRPolicy(csr *certificatesv1beta1.CertificateSigningRequest, req *x509.CertificateRequest, p *csrProfile) error {
+	host := strings.TrimPrefix(req.Subject.CommonName, p.commonNamePrefix)
+	if host == req.Subject.CommonName || host == "" {
+		return errors.NewBadRequest("Common name " + req.Subject.CommonName + " does not start with " + p.commonNamePrefix)
+	}
+	if len(req.EmailAddresses) > 0 || len(req.URIs) > 0 {
+		return errors.NewBadRequest("Only DNS and IP SANs may be requested")
+	}
+	if sets.NewString(loopbackHostnames...).Has(host) {
+		return errors.NewBadRequest("Common name " + req.Subject.CommonName + " does not identify an etcd member")
+	}
+	requested := sets.NewString(req.DNSNames...)
+	for _, ip := range req.IPAddresses {
+		requested.Insert(ip.String())
+	}
+
+	config, err := r.signer.getSignerConfig(r.namespace)
+	if err != nil {
+		return err
+	}
+	pods, err := r.signer.getEtcdPods(r.namespace, config.Settings)
+	if err != nil {
+		return err
+	}
+	for i := range pods {
+		hostnames, err := r.getMemberHostnames(&pods[i], p, config)
+		if err != nil {
+			return err
+		}
+		if !hostnames.Has(host) || !hostnames.IsSuperset(requested) {
+			continue
+		}
+		if isAllowedCSRRequester(csr, config.Settings) || isMemberCSRRequester(csr, &pods[i]) {
+			return nil
+		}
+		return errors.NewBadRequest("Requester " + csr.Spec.Username + " may not request certificates for etcd member " + pods[i].Name)
+	}
+	// The etcd pod is looked up by the host of the common name
+	return errors.NewNotFound(schema.GroupResource{Resource: "pods"}, host)
+}
+
+// isAllowedCSRRequester returns whether csr was requested by one of the users or groups allowed by settings.
+func isAllowedCSRRequester(csr *certificatesv1beta1.CertificateSigningRequest, settings *operatorSettings) bool {
+	if sets.NewString(settings.CSRRequesterUsernames...).Has(csr.Spec.Username) {
+		return true
+	}
+	return sets.NewString(settings.CSRRequesterGroups...).HasAny(csr.Spec.Groups...)
+}
+
+// isMemberCSRRequester returns whether csr was requested by the node running pod or with the
+// service account of pod. Service account tokens bound to a pod must be bound to pod itself.
+func isMemberCSRRequester(csr *certificatesv1beta1.CertificateSigningRequest, pod *corev1.Pod) bool {
+	if pod.Spec.NodeName != "" && csr.Spec.Username == "system:node:"+pod.Spec.NodeName {
+		return true
+	}
+	serviceAccount := pod.Spec.ServiceAccountName
+	if serviceAccount == "" {
+		serviceAccount = "default"
+	}
+	if csr.Spec.Username != "system:serviceaccount:"+pod.Namespace+":"+serviceAccount {
+		return false
+	}
+	podNames, bound := csr.Spec.Extra[podNameExtraKey]
+	return !bound || (len(podNames) == 1 && podNames[0] == pod.Name)
+}
+
+// getMemberHostnames returns the hostnames derived from pod and the hostnames annotated on its
+// member secret signed with the profile of p.
+func (r *CSRSigner) getMemberHostnames(pod *corev1.Pod, p *csrProfile, config *signerConfig) (sets.String, error) {
+	hostnames := sets.NewString(getPodHostnames(pod, config.DNSSuffixes)...)
+	for _, member := range config.Settings.getMemberSecrets(pod) {
+		if member.profile != p.profile {
+			continue
+		}
+		secret, err := r.signer.getSecret(member.name, pod.Namespace)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if names, err := getHostNamesFromSecret(secret); err == nil {
+			hostnames.Insert(names...)
+		}
+	}
+	return hostnames, nil
+}
+
+// signCSR signs req with the CA of the profile of p and returns the PEM encoded certificate chain.
+func (r *CSRSigner) signCSR(req *x509.CertificateRequest, p *csrProfile) ([]byte, error) {
+	config, err := r.signer.getSignerConfig(r.namespace)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := config.getProfile(p.profile)
+	if err != nil {
+		return nil, err
+	}
+	caSecret, _, err := r.signer.getCASecret(profile.CASecretName, config.Settings.CASecretNamespace, config)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := loadSigningCASecret(caSecret)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	cert, err := ca.signRequest(req, profile.Validity.Duration, profile.applyExtensions)
+	if err != nil {
+		return nil, err
+	}
+	signingDuration.WithLabelValues(getKeyAlgorithm(req.PublicKey)).Observe(time.Since(start).Seconds())
+	issued, err := parseCertificate(cert.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if err := r.signer.recordIssued(profile.CASecretName, caSecret.Namespace, issued); err != nil {
+		return nil, err
+	}
+	certificatesSigned.WithLabelValues(profile.CASecretName, "csr").Inc()
+	certificateMetrics.setCSRCertificate(p.profile, issued)
+	return cert.Bytes(), nil
+}
+
+// getCSRProfile returns the profile of req when it was requested for an etcd certificate.
+func getCSRProfile(req *x509.CertificateRequest) (*csrProfile, bool) {
+	if len(req.Subject.Organization) != 1 {
+		return nil, false
+	}
+	p, ok := csrProfiles[req.Subject.Organization[0]]
+	return &p, ok
+}
+
+// parseCSR parses a PEM encoded certificate request.
+func parseCSR(csrPEM []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.NewBadRequest("Unable to decode certificate request PEM")
+	}
+	return x509.ParseCertificateRequest(block.Bytes)
+}
+
+func hasCSRCondition(csr *certificatesv1beta1.CertificateSigningRequest, conditionType certificatesv1beta1.RequestConditionType) bool {
+	for _, c := range csr.Status.Conditions {
+		if c.Type == conditionType {
+			return true
+		}
+	}
+	return false
+}
+
+func setCSRCondition(csr *certificatesv1beta1.CertificateSigningRequest, conditionType certificatesv1beta1.RequestConditionType, reason string, message string) {
+	csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1beta1.CertificateSigningRequestCondition{
+		Type:           conditionType,
+		Reason:         reason,
+		Message:        message,
+		LastUpdateTime: metav1.Now(),
+	})
+}
@@ -0,0 +1,288 @@
+package etcdcertsigner
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net"
+	"testing"
+	"time"
+
+	etcdv1alpha1 "github.com/alaypatel07/etcd-cert-signer/pkg/apis/etcd/v1alpha1"
+	certificatesv1beta1 "k8s.io/api/certificates/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func newTestCSR(t *testing.T, org string, commonName string, dnsNames []string, ips []net.IP) *x509.CertificateRequest {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{
+			Organization: []string{org},
+			CommonName:   commonName,
+		},
+		DNSNames:    dnsNames,
+		IPAddresses: ips,
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestCSRSigner_checkCSRPolicy(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "etcd-0",
+			Namespace: "etcd-namespace",
+			Labels:    map[string]string{"k8s-app": "etcd"},
+		},
+		Spec: corev1.PodSpec{
+			NodeName:           "master-0",
+			ServiceAccountName: "etcd",
+		},
+		Status: corev1.PodStatus{
+			PodIP: "10.0.0.1",
+		},
+	}
+	peerSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "etcd-0-peer",
+			Namespace: "etcd-namespace",
+			Annotations: map[string]string{
+				CertificateHostnames: "etcd-0.etcd.test",
+			},
+		},
+	}
+	config := &etcdv1alpha1.EtcdCertSignerConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: etcdv1alpha1.EtcdCertSignerConfigName},
+		Spec: etcdv1alpha1.EtcdCertSignerConfigSpec{
+			CSRRequesterUsernames: []string{"system:serviceaccount:openshift-etcd:kubecsr"},
+			CSRRequesterGroups:    []string{"system:etcd-csr-requesters"},
+		},
+	}
+	r := &CSRSigner{
+		signer:    &EtcdCertSigner{client: fake.NewFakeClient(pod, peerSecret, config)},
+		namespace: "etcd-namespace",
+	}
+	const podServiceAccount = "system:serviceaccount:etcd-namespace:etcd"
+	memberPeerCSR := func() *x509.CertificateRequest {
+		return newTestCSR(t, "system:etcd-peers", "system:etcd-peer:etcd-0.etcd.test", []string{"etcd-0.etcd.test"}, []net.IP{net.ParseIP("10.0.0.1")})
+	}
+
+	tests := []struct {
+		name         string
+		req          *x509.CertificateRequest
+		spec         certificatesv1beta1.CertificateSigningRequestSpec
+		wantAllowed  bool
+		wantNotFound bool
+	}{
+		{
+			name:        "Peer CSR for a known member",
+			req:         newTestCSR(t, "system:etcd-peers", "system:etcd-peer:etcd-0.etcd.test", []string{"etcd-0.etcd.test"}, []net.IP{net.ParseIP("10.0.0.1")}),
+			wantAllowed: true,
+		},
+		{
+			name:        "Server CSR for the pod name",
+			req:         newTestCSR(t, "system:etcd-servers", "system:etcd-server:etcd-0", []string{"etcd-0"}, nil),
+			wantAllowed: true,
+		},
+		{
+			name:         "Server CSR for a hostname only annotated on the peer secret",
+			req:          newTestCSR(t, "system:etcd-servers", "system:etcd-server:etcd-0.etcd.test", []string{"etcd-0.etcd.test"}, nil),
+			wantNotFound: true,
+		},
+		{
+			name:         "Unknown member",
+			req:          newTestCSR(t, "system:etcd-peers", "system:etcd-peer:etcd-1.etcd.test", []string{"etcd-1.etcd.test"}, nil),
+			wantNotFound: true,
+		},
+		{
+			name:         "SAN of another host",
+			req:          newTestCSR(t, "system:etcd-peers", "system:etcd-peer:etcd-0.etcd.test", []string{"etcd-0.etcd.test", "evil.test"}, nil),
+			wantNotFound: true,
+		},
+		{
+			name: "Common name of a loopback hostname",
+			req:  newTestCSR(t, "system:etcd-servers", "system:etcd-server:localhost", []string{"localhost"}, nil),
+		},
+		{
+			name: "Common name without the profile prefix",
+			req:  newTestCSR(t, "system:etcd-peers", "system:etcd-server:etcd-0.etcd.test", []string{"etcd-0.etcd.test"}, nil),
+		},
+		{
+			name:        "Node running the member",
+			req:         memberPeerCSR(),
+			spec:        certificatesv1beta1.CertificateSigningRequestSpec{Username: "system:node:master-0"},
+			wantAllowed: true,
+		},
+		{
+			name:        "Service account token bound to the member pod",
+			req:         memberPeerCSR(),
+			spec:        certificatesv1beta1.CertificateSigningRequestSpec{Username: podServiceAccount, Extra: map[string]certificatesv1beta1.ExtraValue{podNameExtraKey: {"etcd-0"}}},
+			wantAllowed: true,
+		},
+		{
+			name:        "Allowed requester",
+			req:         memberPeerCSR(),
+			spec:        certificatesv1beta1.CertificateSigningRequestSpec{Username: "system:serviceaccount:openshift-etcd:kubecsr"},
+			wantAllowed: true,
+		},
+		{
+			name:        "Member of an allowed group",
+			req:         memberPeerCSR(),
+			spec:        certificatesv1beta1.CertificateSigningRequestSpec{Username: "alice", Groups: []string{"system:authenticated", "system:etcd-csr-requesters"}},
+			wantAllowed: true,
+		},
+		{
+			name: "Another node",
+			req:  memberPeerCSR(),
+			spec: certificatesv1beta1.CertificateSigningRequestSpec{Username: "system:node:worker-0", Groups: []string{"system:nodes"}},
+		},
+		{
+			name: "Service account token bound to another pod",
+			req:  memberPeerCSR(),
+			spec: certificatesv1beta1.CertificateSigningRequestSpec{Username: podServiceAccount, Extra: map[string]certificatesv1beta1.ExtraValue{podNameExtraKey: {"etcd-1"}}},
+		},
+		{
+			name: "Unrelated user",
+			req:  memberPeerCSR(),
+			spec: certificatesv1beta1.CertificateSigningRequestSpec{Username: "system:serviceaccount:default:default", Groups: []string{"system:serviceaccounts"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, ok := getCSRProfile(tt.req)
+			if !ok {
+				t.Fatalf("getCSRProfile() did not recognize an etcd CSR")
+			}
+			csr := &certificatesv1beta1.CertificateSigningRequest{Spec: tt.spec}
+			if csr.Spec.Username == "" {
+				// Requested from the member pod
+				csr.Spec.Username = podServiceAccount
+			}
+			err := r.checkCSRPolicy(csr, tt.req, p)
+			if (err == nil) != tt.wantAllowed {
+				t.Errorf("checkCSRPolicy() error = %v, wantAllowed %v", err, tt.wantAllowed)
+			}
+			if err != nil && errors.IsNotFound(err) != tt.wantNotFound {
+				t.Errorf("checkCSRPolicy() error = %v, wantNotFound %v", err, tt.wantNotFound)
+			}
+			if err != nil && !tt.wantNotFound && !errors.IsBadRequest(err) {
+				t.Errorf("checkCSRPolicy() error = %v, want a BadRequest", err)
+			}
+		})
+	}
+}
+
+func TestCSRSigner_Reconcile_policy(t *testing.T) {
+	caSecret := newTestCASecret(t, etcdCASecretName, etcdCASecretNamespace)
+	req := newTestCSR(t, "system:etcd-peers", "system:etcd-peer:etcd-0", []string{"etcd-0"}, nil)
+	requestPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: req.Raw})
+	approved := []certificatesv1beta1.CertificateSigningRequestCondition{{Type: certificatesv1beta1.CertificateApproved}}
+
+	tests := []struct {
+		name        string
+		created     time.Duration
+		conditions  []certificatesv1beta1.CertificateSigningRequestCondition
+		wantRequeue bool
+	}{
+		// The member pod is not cached yet
+		{name: "New CSR of an unknown member", created: time.Minute, wantRequeue: true},
+		// Signing is left to whoever approved it
+		{name: "Approved CSR of an unknown member", created: time.Hour, conditions: approved},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			csr := &certificatesv1beta1.CertificateSigningRequest{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "etcd-0-peer",
+					CreationTimestamp: metav1.NewTime(time.Now().Add(-tt.created)),
+				},
+				Spec:   certificatesv1beta1.CertificateSigningRequestSpec{Request: requestPEM, Username: "system:node:master-0"},
+				Status: certificatesv1beta1.CertificateSigningRequestStatus{Conditions: tt.conditions},
+			}
+			// The approval and status of the CSR must not be updated
+			r := &CSRSigner{
+				signer:    &EtcdCertSigner{client: fake.NewFakeClient(csr, caSecret)},
+				namespace: "etcd-namespace",
+			}
+			result, err := r.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Name: csr.Name}})
+			if err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+			if result.Requeue != tt.wantRequeue {
+				t.Errorf("Reconcile() Requeue = %v, want %v", result.Requeue, tt.wantRequeue)
+			}
+		})
+	}
+}
+
+func Test_getCSRProfile(t *testing.T) {
+	tests := []struct {
+		org         string
+		wantProfile string
+		wantOK      bool
+	}{
+		{org: "system:etcd-peers", wantProfile: PeerProfile, wantOK: true},
+		{org: "system:etcd-servers", wantProfile: ServerProfile, wantOK: true},
+		{org: "system:etcd-metrics", wantProfile: MetricsProfile, wantOK: true},
+		{org: "system:nodes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.org, func(t *testing.T) {
+			p, ok := getCSRProfile(newTestCSR(t, tt.org, "foo", nil, nil))
+			if ok != tt.wantOK {
+				t.Fatalf("getCSRProfile() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && p.profile != tt.wantProfile {
+				t.Errorf("getCSRProfile() profile = %v, want %v", p.profile, tt.wantProfile)
+			}
+		})
+	}
+}
+
+func TestCSRSigner_signCSR(t *testing.T) {
+	caSecret := newTestCASecret(t, etcdCASecretName, etcdCASecretNamespace)
+	r := &CSRSigner{
+		signer:    &EtcdCertSigner{client: fake.NewFakeClient(caSecret)},
+		namespace: "etcd-namespace",
+	}
+
+	req := newTestCSR(t, "system:etcd-peers", "system:etcd-peer:etcd-0", []string{"etcd-0"}, []net.IP{net.ParseIP("10.0.0.1")})
+	p, _ := getCSRProfile(req)
+	chain, err := r.signCSR(req, p)
+	if err != nil {
+		t.Fatalf("signCSR() error = %v", err)
+	}
+	cert, err := parseCertificate(chain)
+	if err != nil {
+		t.Fatalf("signCSR() returned an unparseable certificate: %v", err)
+	}
+	if cert.Subject.CommonName != req.Subject.CommonName || len(cert.DNSNames) != 1 || len(cert.IPAddresses) != 1 {
+		t.Errorf("signCSR() subject = %v, DNSNames = %v, IPAddresses = %v", cert.Subject, cert.DNSNames, cert.IPAddresses)
+	}
+	if len(cert.ExtKeyUsage) != 2 {
+		t.Errorf("signCSR() ExtKeyUsage = %v, want the peer profile usages", cert.ExtKeyUsage)
+	}
+	if !isSignedBy(&corev1.Secret{Data: map[string][]byte{"tls.crt": chain}}, caSecret) {
+		t.Errorf("signCSR() certificate not signed by the etcd CA")
+	}
+	if expiry, ok := certificateMetrics.certificates[certificateKey{commonName: req.Subject.CommonName}]; !ok || !expiry.notAfter.Equal(cert.NotAfter) {
+		t.Errorf("signCSR() certificate not exported, got %v", expiry)
+	}
+}
@@ -0,0 +1,71 @@
+package etcdcertsigner
+
+import (
+	"crypto/x509"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// getCertificateDrift returns why the certificate held by secret differs from the certificate
+// that profile would sign for hostnames and identity, or "" when it does not. The validity is not
+// compared, a new validity is only used when the certificate is renewed.
+func getCertificateDrift(secret *corev1.Secret, profile *signingProfile, hostnames []string, identity string) (string, error) {
+	cert, err := parseCertificate(secret.Data["tls.crt"])
+	if err != nil {
+		return "Unable to parse certificate: " + err.Error(), nil
+	}
+
+	// The key usages derived from the key are compared with the current key, a change of key
+	// algorithm is reported below
+	want := &x509.Certificate{PublicKey: cert.PublicKey}
+	if err := profile.apply(want, subjectTemplateData{
+		Identity:   identity,
+		SecretName: secret.Name,
+		Hostname:   hostnames[0],
+	}); err != nil {
+		return "", err
+	}
+	ips, dnsNames := ipAddressesDNSNames(hostnames)
+	want.IPAddresses, want.DNSNames = ips, dnsNames
+
+	if got, want := getSANs(cert), getSANs(want); !got.Equal(want) {
+		return "Hostnames changed from " + strings.Join(got.List(), ",") + " to " + strings.Join(want.List(), ","), nil
+	}
+	if cert.Subject.String() != want.Subject.String() {
+		return "Subject changed from " + cert.Subject.String() + " to " + want.Subject.String(), nil
+	}
+	if cert.KeyUsage != want.KeyUsage || !sameExtKeyUsages(cert.ExtKeyUsage, want.ExtKeyUsage) {
+		return "Key usages of the profile changed", nil
+	}
+	if got, want := getKeyAlgorithm(cert.PublicKey), profile.getKeyAlgorithm(secret); got != want {
+		return "Key algorithm changed from " + got + " to " + want, nil
+	}
+	return "", nil
+}
+
+// getSANs returns the DNS names and IP addresses of cert.
+func getSANs(cert *x509.Certificate) sets.String {
+	sans := sets.NewString(cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans.Insert(ip.String())
+	}
+	return sans
+}
+
+func sameExtKeyUsages(a []x509.ExtKeyUsage, b []x509.ExtKeyUsage) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	usages := map[x509.ExtKeyUsage]bool{}
+	for _, u := range a {
+		usages[u] = true
+	}
+	for _, u := range b {
+		if !usages[u] {
+			return false
+		}
+	}
+	return true
+}
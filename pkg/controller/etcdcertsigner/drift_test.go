@@ -0,0 +1,127 @@
+package etcdcertsigner
+
+import (
+	"crypto"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestEtcdCertSigner_ensureCertificate_drift(t *testing.T) {
+	const namespace = "etcd-namespace"
+	caSecret := newTestCASecret(t, etcdCASecretName, namespace)
+	peerProfile := defaultProfiles(defaultOperatorSettings())[PeerProfile]
+	peerProfile.KeyAlgorithm = KeyAlgorithmECDSAP256
+	hostnames := []string{"etcd-0", "10.0.0.1"}
+	identity := "system:peer:etcd-0"
+
+	tests := []struct {
+		name       string
+		hostnames  []string
+		identity   string
+		profile    func(p *signingProfile)
+		secret     func(s *corev1.Secret)
+		wantReason string
+	}{
+		{
+			name: "No drift",
+		},
+		{
+			name:       "Pod IP changed",
+			hostnames:  []string{"etcd-0", "10.0.0.2"},
+			wantReason: "Hostnames changed",
+		},
+		{
+			name:       "Identity changed",
+			identity:   "system:peer:10.0.0.1",
+			wantReason: "Subject changed",
+		},
+		{
+			name:       "Profile usages changed",
+			profile:    func(p *signingProfile) { p.ExtKeyUsages = []string{"server auth"} },
+			wantReason: "Key usages",
+		},
+		{
+			name:       "Profile key algorithm changed",
+			profile:    func(p *signingProfile) { p.KeyAlgorithm = KeyAlgorithmECDSAP384 },
+			wantReason: "Key algorithm changed",
+		},
+		{
+			name:       "Private key missing",
+			secret:     func(s *corev1.Secret) { delete(s.Data, "tls.key") },
+			wantReason: "Invalid key pair",
+		},
+		{
+			name: "Private key of another certificate",
+			secret: func(s *corev1.Secret) {
+				keyPEM, err := encodePrivateKey(mustGenerateKey(t, KeyAlgorithmECDSAP256))
+				if err != nil {
+					t.Fatal(err)
+				}
+				s.Data["tls.key"] = keyPEM.Bytes()
+			},
+			wantReason: "Invalid key pair",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "etcd-0-peer",
+					Namespace: namespace,
+				},
+			}
+			recorder := record.NewFakeRecorder(2)
+			r := &EtcdCertSigner{client: fake.NewFakeClient(caSecret, secret), recorder: recorder}
+			if _, err := r.ensureCertificate(caSecret, secret, &certificateRequest{profile: &peerProfile, hostnames: hostnames, identity: identity}); err != nil {
+				t.Fatalf("ensureCertificate() error = %v", err)
+			}
+			if event := <-recorder.Events; !strings.Contains(event, "CertificateIssued") {
+				t.Errorf("ensureCertificate() event = %q, want CertificateIssued", event)
+			}
+			issued := string(secret.Data["tls.crt"])
+
+			profile, hostnames, identity := peerProfile, hostnames, identity
+			if tt.hostnames != nil {
+				hostnames = tt.hostnames
+			}
+			if tt.identity != "" {
+				identity = tt.identity
+			}
+			if tt.profile != nil {
+				tt.profile(&profile)
+			}
+			if tt.secret != nil {
+				tt.secret(secret)
+			}
+			if _, err := r.ensureCertificate(caSecret, secret, &certificateRequest{profile: &profile, hostnames: hostnames, identity: identity}); err != nil {
+				t.Fatalf("ensureCertificate() error = %v", err)
+			}
+			if reissued := string(secret.Data["tls.crt"]) != issued; reissued != (tt.wantReason != "") {
+				t.Errorf("ensureCertificate() reissued = %v, want %v", reissued, tt.wantReason != "")
+			}
+			select {
+			case event := <-recorder.Events:
+				if tt.wantReason == "" || !strings.Contains(event, tt.wantReason) {
+					t.Errorf("ensureCertificate() event = %q, want reason %q", event, tt.wantReason)
+				}
+			default:
+				if tt.wantReason != "" {
+					t.Errorf("ensureCertificate() recorded no event, want reason %q", tt.wantReason)
+				}
+			}
+		})
+	}
+}
+
+func mustGenerateKey(t *testing.T, keyAlgorithm string) crypto.Signer {
+	key, err := generateKey(keyAlgorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
@@ -0,0 +1,257 @@
+package etcdcertsigner
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	etcdv1alpha1 "github.com/alaypatel07/etcd-cert-signer/pkg/apis/etcd/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// AddEtcdCertificate creates a new EtcdCertificate Controller and adds it to the Manager. The Manager will set fields
+// on the Controller and Start it when the Manager is Started.
+func AddEtcdCertificate(mgr manager.Manager) error {
+	return addEtcdCertificate(mgr, newEtcdCertificateReconciler(mgr))
+}
+
+// newEtcdCertificateReconciler returns a new reconcile.Reconciler signing the certificates of EtcdCertificates
+func newEtcdCertificateReconciler(mgr manager.Manager) reconcile.Reconciler {
+	return &EtcdCertificateReconciler{
+		signer: &EtcdCertSigner{client: mgr.GetClient(), scheme: mgr.GetScheme(), recorder: mgr.GetRecorder("etcd-cert-signer")},
+	}
+}
+
+// addEtcdCertificate adds a new Controller to mgr with r as the reconcile.Reconciler
+func addEtcdCertificate(mgr manager.Manager, r reconcile.Reconciler) error {
+	c, err := controller.New("etcdcertificate-controller", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to primary resource EtcdCertificate
+	err = c.Watch(&source.Kind{Type: &etcdv1alpha1.EtcdCertificate{}}, &handler.EnqueueRequestForObject{})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to the secrets holding the certificates of EtcdCertificates
+	return c.Watch(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &etcdv1alpha1.EtcdCertificate{},
+	})
+}
+
+// blank assignment to verify that EtcdCertificateReconciler implements reconcile.Reconciler
+var _ reconcile.Reconciler = &EtcdCertificateReconciler{}
+
+// EtcdCertificateReconciler signs the certificates described by EtcdCertificates into their secrets
+type EtcdCertificateReconciler struct {
+	// signer signs the certificates with the configuration and CAs of the EtcdCertificate namespace
+	signer *EtcdCertSigner
+}
+
+// Reconcile signs the certificate of an EtcdCertificate into its secret when it is missing, due for
+// renewal or does not match the spec anymore, and reports the certificate in the status.
+func (r *EtcdCertificateReconciler) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	reqLogger := log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
+	reqLogger.Info("Reconciling EtcdCertificate")
+
+	instance := &etcdv1alpha1.EtcdCertificate{}
+	err := r.signer.client.Get(context.TODO(), request.NamespacedName, instance)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// The secret is garbage collected with its owner
+			certificateMetrics.forgetEtcdCertificate(request.Namespace, request.Name)
+			return reconcile.Result{}, nil
+		}
+		reqLogger.Error(err, "Skip reconcile: Error getting EtcdCertificate", "EtcdCertificate.Namespace", request.Namespace, "EtcdCertificate.Name", request.Name)
+		return reconcile.Result{}, err
+	}
+	status := instance.Status.DeepCopy()
+
+	secret, renewal, err := r.ensureEtcdCertificate(instance)
+	if err != nil {
+		reqLogger.Error(err, "Unable to sign certificate", "EtcdCertificate.Namespace", instance.Namespace, "EtcdCertificate.Name", instance.Name)
+		reason := "SigningFailed"
+		if _, ok := err.(*secretConflictError); ok {
+			reason = "SecretConflict"
+		}
+		r.signer.recordWarning(reason, err, instance)
+		setEtcdCertificateCondition(&instance.Status, etcdv1alpha1.EtcdCertificateReady, corev1.ConditionFalse, reason, err.Error())
+		if err := r.updateStatus(instance, status); err != nil {
+			reqLogger.Error(err, "Unable to update EtcdCertificate status", "EtcdCertificate.Namespace", instance.Namespace, "EtcdCertificate.Name", instance.Name)
+		}
+		return reconcile.Result{}, err
+	}
+
+	if err := setEtcdCertificateStatus(&instance.Status, secret); err != nil {
+		return reconcile.Result{}, err
+	}
+	if cert, err := parseCertificate(secret.Data["tls.crt"]); err == nil {
+		certificateMetrics.setEtcdCertificate(instance.Namespace, instance.Name, secret.Name, instance.Spec.Profile, cert)
+	}
+	if err := r.updateStatus(instance, status); err != nil {
+		reqLogger.Error(err, "Unable to update EtcdCertificate status", "EtcdCertificate.Namespace", instance.Namespace, "EtcdCertificate.Name", instance.Name)
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{RequeueAfter: requeueAfter(time.Now(), renewal)}, nil
+}
+
+// ensureEtcdCertificate signs the certificate of instance into its secret when needed. It returns
+// the secret and when it should be reconciled again.
+func (r *EtcdCertificateReconciler) ensureEtcdCertificate(instance *etcdv1alpha1.EtcdCertificate) (*corev1.Secret, time.Time, error) {
+	spec := &instance.Spec
+	config, err := r.signer.getSignerConfig(instance.Namespace)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	profile, err := config.getProfile(spec.Profile)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if spec.KeyType != "" {
+		if err := validateKeyAlgorithm(spec.KeyType); err != nil {
+			return nil, time.Time{}, err
+		}
+		profile.KeyAlgorithm = spec.KeyType
+	}
+	var host string
+	if len(spec.Hostnames) > 0 {
+		host = spec.Hostnames[0]
+	}
+	identity, err := getEtcdIdentity(spec.Identity, spec.Profile, host, spec.Hostnames)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	req := &certificateRequest{
+		profile:   profile,
+		hostnames: spec.Hostnames,
+		identity:  identity,
+		owner:     instance,
+	}
+	if spec.RenewBefore != nil {
+		if spec.RenewBefore.Duration < 0 || spec.RenewBefore.Duration >= profile.Validity.Duration {
+			return nil, time.Time{}, errors.NewBadRequest("renewBefore must be positive and shorter than the validity of profile " + spec.Profile)
+		}
+		req.renewBefore = spec.RenewBefore.Duration
+	}
+
+	ca, rotating, err := r.signer.getCASecret(profile.CASecretName, config.Settings.CASecretNamespace, config)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	secret, err := r.getOrCreateSecret(instance)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	renewal, err := r.signer.ensureCertificateWithCA(ca, rotating, secret, req, config)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return secret, renewal, nil
+}
+
+// secretConflictError is returned for a secret of an EtcdCertificate that holds data it did not
+// write, or is controlled by another object.
+type secretConflictError struct {
+	message string
+}
+
+func (e *secretConflictError) Error() string {
+	return e.message
+}
+
+// getOrCreateSecret returns the secret of instance, creating an empty TLS secret controlled by
+// instance when it does not exist. An existing secret is only adopted when it has neither a
+// controller nor data, so that the certificates of other objects are never overwritten.
+func (r *EtcdCertificateReconciler) getOrCreateSecret(instance *etcdv1alpha1.EtcdCertificate) (*corev1.Secret, error) {
+	ref := metav1.NewControllerRef(instance, etcdv1alpha1.SchemeGroupVersion.WithKind("EtcdCertificate"))
+	secret, err := r.signer.getSecret(instance.Spec.SecretName, instance.Namespace)
+	if err == nil {
+		if metav1.IsControlledBy(secret, instance) {
+			return secret, nil
+		}
+		if controller := metav1.GetControllerOf(secret); controller != nil {
+			return nil, &secretConflictError{"Secret " + secret.Name + " is controlled by " + controller.Kind + " " + controller.Name}
+		}
+		for _, data := range secret.Data {
+			if len(data) > 0 {
+				return nil, &secretConflictError{"Secret " + secret.Name + " already holds data not written for EtcdCertificate " + instance.Name}
+			}
+		}
+		log.Info("Adopting secret", "Secret.Namespace", secret.Namespace, "Secret.Name", secret.Name)
+		secret.OwnerReferences = append(secret.OwnerReferences, *ref)
+		return secret, r.signer.client.Update(context.TODO(), secret)
+	}
+	if !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	log.Info("Creating secret", "Secret.Namespace", instance.Namespace, "Secret.Name", instance.Spec.SecretName)
+	secret = newTLSSecret(instance.Spec.SecretName, instance.Namespace)
+	secret.OwnerReferences = []metav1.OwnerReference{*ref}
+	if err := r.signer.client.Create(context.TODO(), secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// updateStatus writes the status of instance unless it is still equal to the old status.
+func (r *EtcdCertificateReconciler) updateStatus(instance *etcdv1alpha1.EtcdCertificate, old *etcdv1alpha1.EtcdCertificateStatus) error {
+	if reflect.DeepEqual(&instance.Status, old) {
+		return nil
+	}
+	return r.signer.client.Status().Update(context.TODO(), instance)
+}
+
+// setEtcdCertificateStatus reports the certificate held by secret in status.
+func setEtcdCertificateStatus(status *etcdv1alpha1.EtcdCertificateStatus, secret *corev1.Secret) error {
+	cert, err := parseCertificate(secret.Data["tls.crt"])
+	if err != nil {
+		return err
+	}
+	serial := cert.SerialNumber.Text(16)
+	if status.SerialNumber != serial {
+		now := metav1.Now()
+		status.LastRenewal = &now
+	}
+	notBefore, notAfter := metav1.NewTime(cert.NotBefore), metav1.NewTime(cert.NotAfter)
+	status.NotBefore = &notBefore
+	status.NotAfter = &notAfter
+	status.SerialNumber = serial
+	status.Issuer = cert.Issuer.CommonName
+	setEtcdCertificateCondition(status, etcdv1alpha1.EtcdCertificateReady, corev1.ConditionTrue, "Issued",
+		"Certificate signed by "+cert.Issuer.CommonName+" into secret "+secret.Name)
+	return nil
+}
+
+// setEtcdCertificateCondition sets the condition of conditionType in status, updating its
+// transition time when its status changes.
+func setEtcdCertificateCondition(status *etcdv1alpha1.EtcdCertificateStatus, conditionType etcdv1alpha1.EtcdCertificateConditionType, conditionStatus corev1.ConditionStatus, reason string, message string) {
+	condition := etcdv1alpha1.EtcdCertificateCondition{
+		Type:               conditionType,
+		Status:             conditionStatus,
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            message,
+	}
+	for i := range status.Conditions {
+		if status.Conditions[i].Type != conditionType {
+			continue
+		}
+		if status.Conditions[i].Status == conditionStatus {
+			condition.LastTransitionTime = status.Conditions[i].LastTransitionTime
+		}
+		status.Conditions[i] = condition
+		return
+	}
+	status.Conditions = append(status.Conditions, condition)
+}
@@ -0,0 +1,151 @@
+package etcdcertsigner
+
+import (
+	"context"
+	"testing"
+
+	etcdv1alpha1 "github.com/alaypatel07/etcd-cert-signer/pkg/apis/etcd/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestEtcdCertificateReconciler_Reconcile(t *testing.T) {
+	const namespace = "etcd-namespace"
+	caSecret := newTestCASecret(t, etcdCASecretName, etcdCASecretNamespace)
+	instance := &etcdv1alpha1.EtcdCertificate{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "apiserver-etcd-client",
+			Namespace: namespace,
+			UID:       "instance-uid",
+		},
+		Spec: etcdv1alpha1.EtcdCertificateSpec{
+			SecretName: "apiserver-etcd-client",
+			Hostnames:  []string{"apiserver"},
+			Profile:    ClientProfile,
+			KeyType:    KeyAlgorithmECDSAP256,
+		},
+	}
+
+	s := scheme.Scheme
+	r := &EtcdCertificateReconciler{
+		signer: &EtcdCertSigner{client: fake.NewFakeClientWithScheme(s, caSecret, instance), scheme: s},
+	}
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: instance.Name}}
+	reconcileCertificate := func() (*etcdv1alpha1.EtcdCertificate, *corev1.Secret) {
+		if _, err := r.Reconcile(request); err != nil {
+			t.Fatalf("Reconcile() error = %v", err)
+		}
+		got := &etcdv1alpha1.EtcdCertificate{}
+		if err := r.signer.client.Get(context.TODO(), request.NamespacedName, got); err != nil {
+			t.Fatal(err)
+		}
+		secret, err := r.signer.getSecret(instance.Spec.SecretName, namespace)
+		if err != nil {
+			t.Fatalf("Reconcile() did not create the secret: %v", err)
+		}
+		return got, secret
+	}
+
+	got, secret := reconcileCertificate()
+	cert, err := parseCertificate(secret.Data["tls.crt"])
+	if err != nil {
+		t.Fatalf("Reconcile() secret holds no certificate: %v", err)
+	}
+	if cert.Subject.CommonName != "system:client:apiserver" || getKeyAlgorithm(cert.PublicKey) != KeyAlgorithmECDSAP256 {
+		t.Errorf("Reconcile() certificate subject = %v, key algorithm = %v", cert.Subject, getKeyAlgorithm(cert.PublicKey))
+	}
+	if got.Status.SerialNumber != cert.SerialNumber.Text(16) || got.Status.Issuer != "etcd-signer" || got.Status.LastRenewal == nil {
+		t.Errorf("Reconcile() status = %+v", got.Status)
+	}
+	if len(got.Status.Conditions) != 1 || got.Status.Conditions[0].Status != corev1.ConditionTrue {
+		t.Errorf("Reconcile() conditions = %+v, want Ready", got.Status.Conditions)
+	}
+
+	// A reconcile without changes keeps the certificate
+	if got, _ := reconcileCertificate(); got.Status.SerialNumber != cert.SerialNumber.Text(16) {
+		t.Errorf("Reconcile() re-signed an unchanged certificate")
+	}
+
+	// Changing the spec re-signs the certificate
+	got.Spec.Hostnames = []string{"apiserver", "10.0.0.1"}
+	if err := r.signer.client.Update(context.TODO(), got); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := reconcileCertificate(); got.Status.SerialNumber == cert.SerialNumber.Text(16) {
+		t.Errorf("Reconcile() did not re-sign the certificate for the new hostnames")
+	}
+
+	// An invalid spec is reported in the Ready condition
+	got.Spec.Profile = "unknown"
+	if err := r.signer.client.Update(context.TODO(), got); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(request); err == nil {
+		t.Errorf("Reconcile() error = nil for an unknown profile")
+	}
+	if err := r.signer.client.Get(context.TODO(), request.NamespacedName, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status.Conditions[0].Status != corev1.ConditionFalse || got.Status.Conditions[0].Reason != "SigningFailed" {
+		t.Errorf("Reconcile() conditions = %+v, want not Ready", got.Status.Conditions)
+	}
+}
+
+func TestEtcdCertificateReconciler_getOrCreateSecret(t *testing.T) {
+	instance := &etcdv1alpha1.EtcdCertificate{
+		ObjectMeta: metav1.ObjectMeta{Name: "apiserver-etcd-client", Namespace: "etcd-namespace", UID: "instance-uid"},
+		Spec:       etcdv1alpha1.EtcdCertificateSpec{SecretName: "apiserver-etcd-client"},
+	}
+	other := &etcdv1alpha1.EtcdCertificate{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "etcd-namespace", UID: "other-uid"}}
+	instanceRef := metav1.NewControllerRef(instance, etcdv1alpha1.SchemeGroupVersion.WithKind("EtcdCertificate"))
+	otherRef := metav1.NewControllerRef(other, etcdv1alpha1.SchemeGroupVersion.WithKind("EtcdCertificate"))
+	newSecret := func(data string, refs ...metav1.OwnerReference) *corev1.Secret {
+		secret := newTLSSecret(instance.Spec.SecretName, instance.Namespace)
+		secret.Data["tls.crt"] = []byte(data)
+		secret.OwnerReferences = refs
+		return secret
+	}
+
+	tests := []struct {
+		name         string
+		secret       *corev1.Secret
+		wantConflict bool
+	}{
+		{name: "Missing secret"},
+		{name: "Secret of the EtcdCertificate", secret: newSecret("certificate", *instanceRef)},
+		{name: "Empty secret without controller", secret: newSecret("")},
+		{name: "Secret holding data", secret: newSecret("certificate"), wantConflict: true},
+		{name: "Secret of another controller", secret: newSecret("", *otherRef), wantConflict: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var objs []runtime.Object
+			if tt.secret != nil {
+				objs = append(objs, tt.secret)
+			}
+			r := &EtcdCertificateReconciler{signer: &EtcdCertSigner{client: fake.NewFakeClient(objs...)}}
+			_, err := r.getOrCreateSecret(instance)
+			if _, conflict := err.(*secretConflictError); conflict != tt.wantConflict {
+				t.Fatalf("getOrCreateSecret() error = %v, wantConflict %v", err, tt.wantConflict)
+			}
+			if tt.wantConflict {
+				return
+			}
+			if err != nil {
+				t.Fatalf("getOrCreateSecret() error = %v", err)
+			}
+			got, err := r.signer.getSecret(instance.Spec.SecretName, instance.Namespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !metav1.IsControlledBy(got, instance) || len(got.OwnerReferences) != 1 {
+				t.Errorf("getOrCreateSecret() owners = %v, want the EtcdCertificate", got.OwnerReferences)
+			}
+		})
+	}
+}
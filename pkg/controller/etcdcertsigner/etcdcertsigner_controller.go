@@ -4,30 +4,46 @@ import (
 	"bytes"
 	"context"
 	"crypto/x509"
-	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"strings"
 	"time"
 
-	"github.com/openshift/library-go/pkg/crypto"
+	etcdv1alpha1 "github.com/alaypatel07/etcd-cert-signer/pkg/apis/etcd/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 var log = logf.Log.WithName("controller_certificatesigningrequest")
-var etcdCASecretName = "etcd-ca"
-var etcdCASecretNamespace = "openshift-etcd"
 
+// EtcdCertValidity is the default lifetime of the certificates signed with the built-in profiles.
 const EtcdCertValidity = 3 * 365 * 24 * time.Hour
+
+// podIPRequeuePeriod is how often a pod waiting for its IP is checked on, as its certificates
+// are only signed once the IP can be added to their hostnames.
+const podIPRequeuePeriod = 5 * time.Second
+
+// loopbackHostnames are added to the hostnames of every member certificate.
+var loopbackHostnames = []string{"localhost", "127.0.0.1"}
+
+// EtcdCertRenewalRatio is the fraction of a certificate's lifetime after which it is re-signed.
+const EtcdCertRenewalRatio = 0.8
+
 const (
 	// CertificateNotBeforeAnnotation contains the certificate expiration date in RFC3339 format.
 	CertificateNotBeforeAnnotation = "auth.openshift.io/certificate-not-before"
@@ -37,8 +53,25 @@ const (
 	CertificateIssuer = "auth.openshift.io/certificate-issuer"
 	// CertificateHostnames contains the hostnames used by a signer.
 	CertificateHostnames = "auth.openshift.io/certificate-hostnames"
-	//TODO: think of better name
+	// CertificateEtcdIdentity overrides the etcd identity of a member secret, which defaults to
+	// system:<peer|server|metrics>:<hostname>. The override must be of that form for one of the
+	// hostnames of the certificate.
 	CertificateEtcdIdentity = "auth.openshift.io/certificate-etcd-identity"
+	// CertificateProfile contains the name of the signing profile used for the certificate of a secret.
+	CertificateProfile = "auth.openshift.io/certificate-profile"
+	// CertificateKeyAlgorithm contains the algorithm of the private key generated for a secret, overriding the profile.
+	CertificateKeyAlgorithm = "auth.openshift.io/certificate-key-algorithm"
+	// CertificateUntrustedSinceAnnotation contains when the certificate of a secret was found to no
+	// longer chain to its CA in RFC3339 format. The certificate is re-signed once the grace period
+	// of the CA has passed.
+	CertificateUntrustedSinceAnnotation = "auth.openshift.io/certificate-untrusted-since"
+)
+
+const (
+	// CertificateMemberLabel contains the name of the etcd pod a member secret was created for.
+	CertificateMemberLabel = "auth.openshift.io/etcd-member"
+	// CertificateRoleLabel contains the role of the certificate of a member secret, e.g. peer.
+	CertificateRoleLabel = "auth.openshift.io/certificate-role"
 )
 
 // Add creates a new EtcdCertSigner Controller and adds it to the Manager. The Manager will set fields on the Controller
@@ -49,7 +82,7 @@ func Add(mgr manager.Manager) error {
 
 // newReconciler returns a new reconcile.Reconciler
 func newReconciler(mgr manager.Manager) reconcile.Reconciler {
-	return &EtcdCertSigner{client: mgr.GetClient(), scheme: mgr.GetScheme()}
+	return &EtcdCertSigner{client: mgr.GetClient(), scheme: mgr.GetScheme(), recorder: mgr.GetRecorder("etcd-cert-signer")}
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
@@ -60,25 +93,153 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
-	// Watch for changes to primary resource CertificateSigningRequest
-	err = c.Watch(&source.Kind{Type: &corev1.Pod{}}, &handler.EnqueueRequestForObject{})
+	// Watch for changes to etcd pods, CertificateSigningRequests are watched by the CSR signer
+	signer := &EtcdCertSigner{client: mgr.GetClient()}
+	err = c.Watch(&source.Kind{Type: &corev1.Pod{}}, &handler.EnqueueRequestForObject{}, signer.etcdPodPredicate())
 	if err != nil {
 		return err
 	}
 
-	// TODO(user): Modify this to be the types you create that are owned by the primary resource
-	//	// Watch for changes to secondary resource Pods and requeue the owner CertificateSigningRequest
-	//	//err = c.Watch(&source.Kind{Type: &corev1.Pod{}}, &handler.EnqueueRequestForOwner{
-	//	//	IsController: true,
-	//	//	OwnerType:    &certificatesv1beta1.CertificateSigningRequest{},
-	//	//})
-	//	//if err != nil {
-	//	//	return err
-	//	//}
+	// Reconcile every etcd pod when the operator settings change
+	err = c.Watch(&source.Kind{Type: &etcdv1alpha1.EtcdCertSignerConfig{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(signer.getEtcdPodRequests),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Reconcile the pod of a member secret when it changes, and the pods signed by a CA when its secret changes
+	secretHandler := &handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(signer.getSecretRequests)}
+	err = c.Watch(&source.Kind{Type: &corev1.Secret{}}, secretHandler)
+	if err != nil {
+		return err
+	}
+	if m, ok := mgr.(namespaceCacheGetter); ok {
+		// The CA secrets are outside of the watched namespace
+		caSecrets := &source.Kind{Type: &corev1.Secret{}}
+		if err := caSecrets.InjectCache(m.GetNamespaceCache()); err != nil {
+			return err
+		}
+		err = c.Watch(caSecrets, secretHandler)
+		if err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
+// namespaceCacheGetter is implemented by the Managers reading the objects of a namespace they do
+// not watch from a cache of its own.
+type namespaceCacheGetter interface {
+	GetNamespaceCache() cache.Cache
+}
+
+// etcdPodPredicate filters the pod events down to the etcd pods selected by the operator settings.
+func (r *EtcdCertSigner) etcdPodPredicate() predicate.Funcs {
+	return predicate.Funcs{
+		CreateFunc:  func(e event.CreateEvent) bool { return r.isEtcdPod(e.Meta) },
+		UpdateFunc:  func(e event.UpdateEvent) bool { return r.isEtcdPod(e.MetaNew) },
+		DeleteFunc:  func(e event.DeleteEvent) bool { return r.isEtcdPod(e.Meta) },
+		GenericFunc: func(e event.GenericEvent) bool { return r.isEtcdPod(e.Meta) },
+	}
+}
+
+// isEtcdPod returns whether pod is selected by the operator settings. Pods are let through when
+// the settings cannot be read, for Reconcile to report the error.
+func (r *EtcdCertSigner) isEtcdPod(pod metav1.Object) bool {
+	settings, err := r.getOperatorSettings()
+	if err != nil {
+		return true
+	}
+	return settings.isEtcdPod(pod.GetLabels())
+}
+
+// getEtcdPodRequests returns a request for each etcd pod watched by the operator.
+func (r *EtcdCertSigner) getEtcdPodRequests(handler.MapObject) []reconcile.Request {
+	settings, err := r.getOperatorSettings()
+	if err != nil {
+		log.Error(err, "Unable to get operator settings")
+		return nil
+	}
+	pods, err := r.getEtcdPods("", settings)
+	if err != nil {
+		log.Error(err, "Unable to list etcd pods")
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(pods))
+	for _, pod := range pods {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}})
+	}
+	return requests
+}
+
+// getSecretRequests maps a member secret to its pod, and a CA secret to the etcd pods signed by its CA.
+func (r *EtcdCertSigner) getSecretRequests(obj handler.MapObject) []reconcile.Request {
+	settings, err := r.getOperatorSettings()
+	if err != nil {
+		log.Error(err, "Unable to get operator settings")
+		return nil
+	}
+	if pod := r.getMemberSecretPod(obj.Meta, settings); pod != nil {
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}}}
+	}
+	if obj.Meta.GetNamespace() != settings.CASecretNamespace {
+		return nil
+	}
+
+	pods, err := r.getEtcdPods("", settings)
+	if err != nil {
+		log.Error(err, "Unable to list etcd pods")
+		return nil
+	}
+	configs := map[string]*signerConfig{}
+	var requests []reconcile.Request
+	for _, pod := range pods {
+		config, ok := configs[pod.Namespace]
+		if !ok {
+			config, err = r.getSignerConfig(pod.Namespace)
+			if err != nil {
+				log.Error(err, "Unable to get operator config", "ConfigMap.Namespace", pod.Namespace, "ConfigMap.Name", signerConfigMapName)
+				continue
+			}
+			configs[pod.Namespace] = config
+		}
+		if config.usesCASecret(obj.Meta.GetName()) {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}})
+		}
+	}
+	return requests
+}
+
+// getMemberSecretPod returns the etcd pod of the member secret, found by its controller, its member
+// label or its name, or nil when secret is not a member secret.
+func (r *EtcdCertSigner) getMemberSecretPod(secret metav1.Object, settings *operatorSettings) *corev1.Pod {
+	var names []string
+	if controller := metav1.GetControllerOf(secret); controller != nil && controller.Kind == "Pod" {
+		names = append(names, controller.Name)
+	}
+	if name, ok := secret.GetLabels()[CertificateMemberLabel]; ok {
+		names = append(names, name)
+	}
+	for _, suffix := range []string{settings.PeerSecretSuffix, settings.ServerSecretSuffix, settings.MetricsSecretSuffix} {
+		if name := strings.TrimSuffix(secret.GetName(), suffix); name != secret.GetName() {
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range names {
+		pod := &corev1.Pod{}
+		if err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: secret.GetNamespace(), Name: name}, pod); err != nil {
+			continue
+		}
+		if settings.isEtcdPod(pod.GetLabels()) {
+			return pod
+		}
+	}
+	return nil
+}
+
 // blank assignment to verify that EtcdCertSigner implements reconcile.Reconciler
 var _ reconcile.Reconciler = &EtcdCertSigner{}
 
@@ -88,6 +249,8 @@ type EtcdCertSigner struct {
 	// that reads objects from the cache and writes to the apiserver
 	client client.Client
 	scheme *runtime.Scheme
+	// recorder records the events of the reconciled secrets, it is optional
+	recorder record.EventRecorder
 }
 
 // Reconcile watches on etcd cluster pods and checks if secrets for their certs are appropriately created.
@@ -103,9 +266,10 @@ func (r *EtcdCertSigner) Reconcile(request reconcile.Request) (reconcile.Result,
 	if err != nil {
 		if errors.IsNotFound(err) {
 			// Request object not found, could have been deleted after reconcile request.
-			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
+			// Its member secrets are garbage collected or were cleaned up by the finalizer according to their policy.
 			// Return and don't requeue
-			reqLogger.Error(err, "Skip reconcile: Pod not found", "Pod.Namespace", request.Namespace, "Pod.Name", request.Name)
+			reqLogger.Info("Skip reconcile: Pod not found", "Pod.Namespace", request.Namespace, "Pod.Name", request.Name)
+			certificateMetrics.forgetPod(request.Namespace, request.Name)
 			return reconcile.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
@@ -113,153 +277,334 @@ func (r *EtcdCertSigner) Reconcile(request reconcile.Request) (reconcile.Result,
 		return reconcile.Result{}, err
 	}
 
-	if ok := etcdPod(pod.GetLabels()); !ok {
+	config, err := r.getSignerConfig(pod.Namespace)
+	if err != nil {
+		reqLogger.Error(err, "Error getting operator config", "ConfigMap.Namespace", pod.Namespace, "ConfigMap.Name", signerConfigMapName)
+		return reconcile.Result{}, err
+	}
+
+	if ok := config.Settings.isEtcdPod(pod.GetLabels()); !ok {
 		// Not an etcd pod, remove the key from the queue
 		reqLogger.Info("Skip reconcile: Not an etcd pod", "Pod.Namespace", pod.Namespace, "Pod.Name", pod.Name)
 		return reconcile.Result{}, nil
 	}
 
-	// TODO: change namespace to openshift-config-managed
-	etcdCA, err := r.getSecret(etcdCASecretName, pod.Namespace)
-	if err != nil {
-		if errors.IsNotFound(err) {
-			reqLogger.Error(err, "CA Secret does not exist", "Secret.Namespace", etcdCASecretNamespace, "Secret.Name", etcdCASecretName)
+	if pod.DeletionTimestamp != nil {
+		// Deleted pods get no new certificates, only the cleanup of their member secrets
+		certificateMetrics.forgetPod(pod.Namespace, pod.Name)
+		if err := r.finalizeMember(pod, config); err != nil {
+			reqLogger.Error(err, "Unable to clean up member secrets", "Pod.Namespace", pod.Namespace, "Pod.Name", pod.Name)
 			return reconcile.Result{}, err
-		} else {
-			reqLogger.Error(err, "Error getting CA Secret", "Secret.Namespace ", etcdCASecretNamespace, "Secret.Name", etcdCASecretName)
 		}
+		return reconcile.Result{}, nil
 	}
 
-	peerSecret, err := r.getSecret(getPeerSecretName(pod), pod.Namespace)
-	//fmt.Println(peerSecret)
+	policy, err := config.Settings.getMemberSecretPolicy(pod)
 	if err != nil {
-		if errors.IsNotFound(err) {
-			reqLogger.Error(err, "Peer secret does not exists", "Secret.Namespace ", pod.Namespace, "Secret.Name", getPeerSecretName(pod))
-		} else {
-			reqLogger.Error(err, "Error getting peer secret", "Secret.Namespace ", pod.Namespace, "Secret.Name", getPeerSecretName(pod))
-		}
+		reqLogger.Error(err, "Invalid member secret policy", "Pod.Namespace", pod.Namespace, "Pod.Name", pod.Name)
+		r.recordWarning("InvalidMemberSecretPolicy", err, pod)
 		return reconcile.Result{}, err
 	}
-	if _, ok := peerSecret.Data["tls.crt"]; !ok {
-		//this controller assumes that secret for CA is populated
-		// create the peer certs only if they dont exists
-		pCert, pKey, err := getCerts(etcdCA, peerSecret, "system:peers")
+	if err := r.ensureMemberFinalizer(pod, policy); err != nil {
+		reqLogger.Error(err, "Unable to update member secrets finalizer", "Pod.Namespace", pod.Namespace, "Pod.Name", pod.Name)
+		return reconcile.Result{}, err
+	}
+	owner, err := r.getMemberSecretOwner(pod, policy)
+	if err != nil {
+		reqLogger.Error(err, "Unable to find member secrets owner", "Pod.Namespace", pod.Namespace, "Pod.Name", pod.Name)
+		return reconcile.Result{}, err
+	}
+
+	// The pod only gets its IP once its member secrets can be mounted, create the missing ones first
+	members := config.Settings.getMemberSecrets(pod)
+	secrets := make([]*corev1.Secret, len(members))
+	for i, member := range members {
+		secrets[i], err = r.getOrCreateMemberSecret(pod, member, owner)
 		if err != nil {
-			reqLogger.Error(err, "Error signing the certificate")
+			reqLogger.Error(err, "Error getting member secret", "Secret.Namespace ", pod.Namespace, "Secret.Name", member.name)
+			return reconcile.Result{}, err
 		}
+	}
 
-		err = r.populateSecret(peerSecret, pCert, pKey)
+	if pod.Status.PodIP == "" {
+		// Signing before the pod is scheduled would leave the pod IP out of the certificates
+		reqLogger.Info("Skip reconcile: Waiting for the pod IP", "Pod.Namespace", pod.Namespace, "Pod.Name", pod.Name)
+		return reconcile.Result{RequeueAfter: podIPRequeuePeriod}, nil
+	}
+
+	var renewals []time.Time
+	for i, member := range members {
+		secret := secrets[i]
+
+		profileName := getProfileName(secret, member.profile)
+		profile, err := config.getProfile(profileName)
 		if err != nil {
-			reqLogger.Error(err, "Unable to update peer secret", "Secret.Namespace", peerSecret.Namespace, "Secret.Name", peerSecret.Name)
+			reqLogger.Error(err, "Unable to find signing profile", "Secret.Namespace", secret.Namespace, "Secret.Name", secret.Name, "Profile", profileName)
+			r.recordWarning("UnknownProfile", err, pod, secret)
+			return reconcile.Result{}, err
 		}
-	}
 
-	serverSecret, err := r.getSecret(getServerSecretName(pod), pod.Namespace)
-	if err != nil {
-		if errors.IsNotFound(err) {
-			reqLogger.Error(err, "Server secret does not exists", "Secret.Namespace ", pod.Namespace, "Secret.Name", getServerSecretName(pod))
-		} else {
-			reqLogger.Error(err, "Error getting server secret", "Secret.Namespace ", pod.Namespace, "Secret.Name", getServerSecretName(pod))
+		if revocationRequested(secret) {
+			if err := r.revokeCertificate(profile.CASecretName, config.Settings.CASecretNamespace, secret); err != nil {
+				reqLogger.Error(err, "Unable to revoke certificate", "Secret.Namespace", secret.Namespace, "Secret.Name", secret.Name)
+				return reconcile.Result{}, err
+			}
 		}
-		return reconcile.Result{}, err
-	}
-	if _, ok := serverSecret.Data["tls.crt"]; !ok {
-		//this controller assumes that secret for CA is populated
-		// create server the certs only if they dont exists
-		sCert, sKey, err := getCerts(etcdCA, peerSecret, "system:servers")
+
+		ca, rotating, err := r.getCASecret(profile.CASecretName, config.Settings.CASecretNamespace, config)
 		if err != nil {
-			reqLogger.Error(err, "Error signing the certificate")
+			if errors.IsNotFound(err) {
+				reqLogger.Error(err, "CA Secret does not exist", "Secret.Namespace", config.Settings.CASecretNamespace, "Secret.Name", profile.CASecretName)
+				r.recordWarning("CANotFound", err, pod)
+			} else {
+				reqLogger.Error(err, "Error getting CA Secret", "Secret.Namespace ", config.Settings.CASecretNamespace, "Secret.Name", profile.CASecretName)
+			}
+			return reconcile.Result{}, err
+		}
+		if caCert, err := parseCertificate(ca.Data["tls.crt"]); err == nil {
+			certificateMetrics.setCA(ca.Namespace, ca.Name, caCert)
 		}
 
-		err = r.populateSecret(serverSecret, sCert, sKey)
+		crlRefresh, err := r.ensureCRL(ca, profile.CASecretName, config)
 		if err != nil {
-			reqLogger.Error(err, "Unable to update server secret", "Secret.Namespace", serverSecret.Namespace, "Secret.Name", serverSecret.Name)
+			reqLogger.Error(err, "Unable to publish CRL", "Secret.Namespace", ca.Namespace, "Secret.Name", profile.CASecretName)
+			return reconcile.Result{}, err
+		}
+		renewals = append(renewals, crlRefresh)
+
+		//this controller assumes that secret for CA is populated
+		// create the certs if they dont exist or are due for renewal
+		hostnames := getHostnames(pod, secret, config.DNSSuffixes)
+		identity, err := getEtcdIdentity(secret.GetAnnotations()[CertificateEtcdIdentity], member.role, getPodHostname(pod), hostnames)
+		if err != nil {
+			reqLogger.Error(err, "Invalid etcd identity", "Secret.Namespace", secret.Namespace, "Secret.Name", secret.Name)
+			r.recordWarning("InvalidIdentity", err, pod, secret)
+			return reconcile.Result{}, err
+		}
+		renewal, err := r.ensureCertificateWithCA(ca, rotating, secret, &certificateRequest{
+			profile:   profile,
+			hostnames: hostnames,
+			identity:  identity,
+			owner:     pod,
+		}, config)
+		if err != nil {
+			reqLogger.Error(err, "Unable to sign certificate", "Secret.Namespace", secret.Namespace, "Secret.Name", secret.Name, "Profile", profileName)
+			r.recordWarning("SigningFailed", err, pod, secret)
+			return reconcile.Result{}, err
 		}
+		if cert, err := parseCertificate(secret.Data["tls.crt"]); err == nil {
+			certificateMetrics.setCertificate(secret.Namespace, secret.Name, pod.Name, member.role, cert)
+		}
+		renewals = append(renewals, renewal)
 	}
 
-	//m, err := r.getSecret(pod.Name + "-metrics", pod.Namespace)
-	//if err != nil {
-	//	if errors.IsNotFound(err) {
-	//		reqLogger.Error(err, "Metrics secret does not exists", "Metrics Secret Namespace ", pod.Namespace, "Secret.Name", pod.Name + "-metrics")
-	//	} else {
-	//		reqLogger.Error(err, "Error getting metrics secret", "Secret Namespace ", pod.Namespace, "Secret.Name", pod.Name + "-metrics")
-	//	}
-	//	return reconcile.Result{}, err
-	//}
+	// Come back when the first of the certificates is due for renewal
+	return reconcile.Result{RequeueAfter: requeueAfter(time.Now(), renewals...)}, nil
+}
 
-	//TODO populate metrics secret with signed certificate
-	return reconcile.Result{}, nil
+// getOrCreateMemberSecret returns the secret of member controlled by owner, creating a TLS secret
+// without a key pair when it does not exist. owner is only nil for retained member secrets.
+func (r *EtcdCertSigner) getOrCreateMemberSecret(pod *corev1.Pod, member memberSecret, owner *metav1.OwnerReference) (*corev1.Secret, error) {
+	secret, err := r.getSecret(member.name, pod.Namespace)
+	if err == nil {
+		return secret, r.ensureMemberSecretOwner(secret, owner)
+	}
+	if !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	secret = newTLSSecret(member.name, pod.Namespace)
+	secret.Labels = map[string]string{
+		CertificateMemberLabel: pod.Name,
+		CertificateRoleLabel:   member.role,
+	}
+	// The etcd identity is not annotated, so that it follows the hostnames of the member when it
+	// is signed rather than being frozen to the hostnames it had when it was created
+	secret.Annotations = map[string]string{
+		CertificateProfile: member.profile,
+	}
+	if owner != nil {
+		secret.OwnerReferences = []metav1.OwnerReference{*owner}
+	}
+	log.Info("Creating member secret", "Secret.Namespace", secret.Namespace, "Secret.Name", secret.Name)
+	if err := r.client.Create(context.TODO(), secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
 }
 
-func getCerts(etcdCASecret *corev1.Secret, targetSecret *corev1.Secret, org string) (*bytes.Buffer, *bytes.Buffer, error) {
-	err := ensureCASecret(etcdCASecret)
-	if err != nil {
-		return nil, nil, err
+// newTLSSecret returns a TLS secret called name in namespace holding an empty key pair, which the
+// API requires to be set.
+func newTLSSecret(name string, namespace string) *corev1.Secret {
+	return &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Data: map[string][]byte{
+			"tls.crt": {},
+			"tls.key": {},
+		},
+		Type: corev1.SecretTypeTLS,
 	}
-	hostnames, err := getHostNamesFromSecret(targetSecret)
+}
+
+// certificateRequest describes the certificate a secret should hold.
+type certificateRequest struct {
+	profile   *signingProfile
+	hostnames []string
+	identity  string
+	// renewBefore is how long before its expiry the certificate is re-signed, the renewal ratio
+	// of its lifetime is used when it is zero.
+	renewBefore time.Duration
+	// gracePeriod is how long a certificate that no longer chains to the CA is kept.
+	gracePeriod time.Duration
+	// caBundle is written to the ca.crt key of the secret in place of the root of the signing CA,
+	// e.g. the roots of both the old and the new CA while the CA is rotated.
+	caBundle []byte
+	// owner is the object the certificate is signed for, e.g. the etcd pod, which also gets the
+	// events of the secret. It is optional.
+	owner runtime.Object
+}
+
+// ensureCertificate signs the certificate described by req into secret when it has no valid key
+// pair, when the certificate has passed its renewal time, when it drifted from req, or when it no
+// longer chains to etcdCA for longer than the grace period. The trusted roots of a kept certificate
+// are updated in place. It returns when the certificate held by the secret is due to be re-signed.
+func (r *EtcdCertSigner) ensureCertificate(etcdCA *corev1.Secret, secret *corev1.Secret, req *certificateRequest) (time.Time, error) {
+	root := req.caBundle
+	if len(root) == 0 {
+		var err error
+		if root, err = getCARootPEM(etcdCA); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	if renewal, err := getRenewalTimeBefore(secret, req.renewBefore); err == nil && time.Now().Before(renewal) {
+		var reason string
+		if err := validateKeyPair(secret); err != nil {
+			reason = "Invalid key pair: " + err.Error()
+		} else if err := verifyIssuedBy(secret, etcdCA); err != nil {
+			reason = "Certificate does not chain to CA " + etcdCA.Name + ": " + err.Error()
+			deadline, err := r.getUntrustedDeadline(secret, req.gracePeriod, reason)
+			if err != nil {
+				return time.Time{}, err
+			}
+			if time.Now().Before(deadline) {
+				if deadline.Before(renewal) {
+					renewal = deadline
+				}
+				return renewal, r.ensureCARoot(secret, root)
+			}
+		} else {
+			if err := r.clearUntrusted(secret); err != nil {
+				return time.Time{}, err
+			}
+			if reason, err = getCertificateDrift(secret, req.profile, req.hostnames, req.identity); err != nil {
+				return time.Time{}, err
+			}
+		}
+		if reason == "" {
+			return renewal, r.ensureCARoot(secret, root)
+		}
+		log.Info("Reissuing certificate", "Secret.Namespace", secret.Namespace, "Secret.Name", secret.Name, "Reason", reason)
+		r.recordCertificateEvent(secret, req, corev1.EventTypeNormal, "CertificateReissued", reason)
+	}
+	_, err := parseCertificate(secret.Data["tls.crt"])
+	renewed := err == nil
+
+	start := time.Now()
+	cert, key, err := getCerts(etcdCA, secret, req.profile, req.hostnames, req.identity)
 	if err != nil {
-		return nil, nil, err
+		return time.Time{}, err
 	}
-	cn, err := getCommonNameFromSecret(targetSecret)
-	etcdCAKeyPair, err := crypto.GetCAFromBytes(etcdCASecret.Data["tls.crt"], etcdCASecret.Data["tls.key"])
+	signingDuration.WithLabelValues(req.profile.getKeyAlgorithm(secret)).Observe(time.Since(start).Seconds())
+	issued, err := parseCertificate(cert.Bytes())
 	if err != nil {
-		return nil, nil, err
+		return time.Time{}, err
 	}
-
-	identity, ok := targetSecret.GetAnnotations()[CertificateEtcdIdentity]
-	if !ok {
-		return nil, nil, errors.NewBadRequest("Etcd Identity not found")
+	if err := r.recordIssued(req.profile.CASecretName, etcdCA.Namespace, issued); err != nil {
+		return time.Time{}, err
 	}
+	if err := r.populateSecret(secret, cert, key, root); err != nil {
+		return time.Time{}, err
+	}
+	reason, action, signing := "CertificateIssued", "Signed", "issued"
+	if renewed {
+		reason, action, signing = "CertificateRenewed", "Renewed", "renewed"
+	}
+	certificatesSigned.WithLabelValues(req.profile.CASecretName, signing).Inc()
+	r.recordCertificateEvent(secret, req, corev1.EventTypeNormal, reason, fmt.Sprintf("%s certificate %s of secret %s with CA %s, expires %s",
+		action, issued.SerialNumber.Text(16), secret.Name, issued.Issuer.CommonName, issued.NotAfter.UTC().Format(time.RFC3339)))
+	return getRenewalTimeBefore(secret, req.renewBefore)
+}
 
-	certConfig, err := etcdCAKeyPair.MakeServerCertForDuration(sets.NewString(hostnames...), EtcdCertValidity, func(cert *x509.Certificate) error {
-
-		cert.Issuer = pkix.Name{
-			OrganizationalUnit: []string{"openshift"},
-			CommonName:         cn,
+// getUntrustedDeadline returns until when the certificate of secret, which no longer chains to its
+// CA because of reason, is kept. The grace period starts when this is first noticed, which is
+// recorded on secret.
+func (r *EtcdCertSigner) getUntrustedDeadline(secret *corev1.Secret, gracePeriod time.Duration, reason string) (time.Time, error) {
+	if gracePeriod <= 0 {
+		return time.Time{}, nil
+	}
+	since, err := time.Parse(time.RFC3339, secret.GetAnnotations()[CertificateUntrustedSinceAnnotation])
+	if err != nil {
+		since = time.Now()
+		log.Info("Keeping certificate during the CA grace period", "Secret.Namespace", secret.Namespace, "Secret.Name", secret.Name, "GracePeriod", gracePeriod.String(), "Reason", reason)
+		r.recordEvent(secret, corev1.EventTypeWarning, "CertificateUntrusted", reason)
+		if secret.Annotations == nil {
+			secret.Annotations = make(map[string]string)
 		}
-		cert.Subject = pkix.Name{
-			Organization: []string{org},
-			CommonName:   identity,
+		secret.Annotations[CertificateUntrustedSinceAnnotation] = since.UTC().Format(time.RFC3339)
+		if err := r.client.Update(context.TODO(), secret); err != nil {
+			return time.Time{}, err
 		}
-		cert.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth}
+	}
+	return since.Add(gracePeriod), nil
+}
 
-		// TODO: Extended Key Usage:
-		// All profiles expect a x509.ExtKeyUsageCodeSigning set on extended Key Usages
-		// need to investigage: https://github.com/etcd-io/etcd/issues/9398#issuecomment-435340312
-		// TODO: some extensions are missing form cfssl.
-		// e.g.
-		//	X509v3 Subject Key Identifier:
-		//		B7:30:0B:CF:47:4E:21:AE:13:60:74:42:B0:D9:C4:F3:26:69:63:03
-		//	X509v3 Authority Key Identifier:
-		//		keyid:9B:C0:6B:0C:8E:5C:73:6A:83:B1:E4:54:97:D3:62:18:8A:9C:BC:1E
-		// TODO: Change serial number logic, to something as follows.
-		// The following is taken from CFSSL library.
-		// If CFSSL is providing the serial numbers, it makes
-		// sense to use the max supported size.
-
-		//	serialNumber := make([]byte, 20)
-		//	_, err = io.ReadFull(rand.Reader, serialNumber)
-		//	if err != nil {
-		//		return err
-		//	}
-		//
-		//	// SetBytes interprets buf as the bytes of a big-endian
-		//	// unsigned integer. The leading byte should be masked
-		//	// off to ensure it isn't negative.
-		//	serialNumber[0] &= 0x7F
-		//	cert.SerialNumber = new(big.Int).SetBytes(serialNumber)
+// ensureCARoot writes root to the ca.crt key of secret when it holds other roots, without
+// re-signing its certificate.
+func (r *EtcdCertSigner) ensureCARoot(secret *corev1.Secret, root []byte) error {
+	if bytes.Equal(secret.Data[CARootKey], root) {
 		return nil
-	})
-	if err != nil {
-		return nil, nil, err
 	}
+	log.Info("Updating trusted CA roots", "Secret.Namespace", secret.Namespace, "Secret.Name", secret.Name)
+	secret.Data[CARootKey] = root
+	return r.client.Update(context.TODO(), secret)
+}
 
-	certBytes := &bytes.Buffer{}
-	keyBytes := &bytes.Buffer{}
-	if err := certConfig.WriteCertConfig(certBytes, keyBytes); err != nil {
+// clearUntrusted removes the grace period recorded on secret once its certificate chains to its CA again.
+func (r *EtcdCertSigner) clearUntrusted(secret *corev1.Secret) error {
+	if _, ok := secret.GetAnnotations()[CertificateUntrustedSinceAnnotation]; !ok {
+		return nil
+	}
+	delete(secret.Annotations, CertificateUntrustedSinceAnnotation)
+	return r.client.Update(context.TODO(), secret)
+}
+
+func getCerts(etcdCASecret *corev1.Secret, targetSecret *corev1.Secret, profile *signingProfile, hostnames []string, identity string) (*bytes.Buffer, *bytes.Buffer, error) {
+	if len(hostnames) == 0 {
+		return nil, nil, errors.NewBadRequest("Hostnames not found")
+	}
+	etcdCAKeyPair, err := loadSigningCASecret(etcdCASecret)
+	if err != nil {
 		return nil, nil, err
 	}
-	return certBytes, keyBytes, nil
+
+	return etcdCAKeyPair.makeServerCert(hostnames, profile.Validity.Duration, profile.getKeyAlgorithm(targetSecret), func(cert *x509.Certificate) error {
+		// TODO: Extended Key Usage:
+		// All profiles expect a x509.ExtKeyUsageCodeSigning set on extended Key Usages
+		// need to investigage: https://github.com/etcd-io/etcd/issues/9398#issuecomment-435340312
+		return profile.apply(cert, subjectTemplateData{
+			Identity:   identity,
+			SecretName: targetSecret.Name,
+			Hostname:   hostnames[0],
+		})
+	})
 }
 
 func ensureCASecret(secret *corev1.Secret) error {
@@ -272,16 +617,6 @@ func ensureCASecret(secret *corev1.Secret) error {
 	return nil
 }
 
-func getCommonNameFromSecret(secret *corev1.Secret) (string, error) {
-	if strings.Contains(secret.Name, "peer") || strings.Contains(secret.Name, "server") {
-		return "etcd-signer", nil
-	}
-	if strings.Contains(secret.Name, "metric") {
-		return "etcd-metric-signer", nil
-	}
-	return "", errors.NewBadRequest("Unable to recognise secret name")
-}
-
 func getHostNamesFromSecret(secret *corev1.Secret) ([]string, error) {
 	hostnames, ok := secret.GetAnnotations()[CertificateHostnames]
 	if !ok {
@@ -290,6 +625,105 @@ func getHostNamesFromSecret(secret *corev1.Secret) ([]string, error) {
 	return strings.Split(hostnames, ","), nil
 }
 
+// getHostnames returns the hostnames of the certificate of the member secret of pod: the
+// hostnames annotated on secret followed by the hostnames derived from pod, without duplicates.
+func getHostnames(pod *corev1.Pod, secret *corev1.Secret, dnsSuffixes []string) []string {
+	var hostnames []string
+	if annotated, err := getHostNamesFromSecret(secret); err == nil {
+		hostnames = append(hostnames, annotated...)
+	}
+	hostnames = append(hostnames, getPodHostnames(pod, dnsSuffixes)...)
+
+	seen := sets.NewString()
+	unique := hostnames[:0]
+	for _, hostname := range hostnames {
+		hostname = strings.TrimSpace(hostname)
+		if hostname == "" || seen.Has(hostname) {
+			continue
+		}
+		seen.Insert(hostname)
+		unique = append(unique, hostname)
+	}
+	return unique
+}
+
+// getEtcdIdentity returns the etcd identity of a certificate with role: identity when it is set,
+// or system:<role>:<host>. A BadRequest error is returned when identity is set but not of that
+// form for one of hostnames, or when it names a loopback host shared by every member.
+func getEtcdIdentity(identity string, role string, host string, hostnames []string) (string, error) {
+	prefix := "system:" + role + ":"
+	if identity == "" {
+		if host == "" {
+			return "", errors.NewBadRequest("Hostnames not found")
+		}
+		identity = prefix + host
+	}
+	host = strings.TrimPrefix(identity, prefix)
+	if host == identity {
+		return "", errors.NewBadRequest("Etcd identity " + identity + " does not start with " + prefix)
+	}
+	if sets.NewString(loopbackHostnames...).Has(host) {
+		return "", errors.NewBadRequest("Etcd identity " + identity + " does not identify an etcd member")
+	}
+	if !sets.NewString(hostnames...).Has(host) {
+		return "", errors.NewBadRequest("Etcd identity " + identity + " is not one of the certificate hostnames")
+	}
+	return identity, nil
+}
+
+// getPodHostname returns the hostname of pod, which defaults to its name.
+func getPodHostname(pod *corev1.Pod) string {
+	if pod.Spec.Hostname != "" {
+		return pod.Spec.Hostname
+	}
+	return pod.Name
+}
+
+// getPodHostnames returns the name and hostname of pod, both alone and followed by each of
+// dnsSuffixes, its node name, its IP and the loopback hostnames.
+func getPodHostnames(pod *corev1.Pod, dnsSuffixes []string) []string {
+	names := []string{pod.Name}
+	if pod.Spec.Hostname != "" && pod.Spec.Hostname != pod.Name {
+		names = append(names, pod.Spec.Hostname)
+	}
+	hostnames := append([]string{}, names...)
+	for _, suffix := range dnsSuffixes {
+		for _, name := range names {
+			hostnames = append(hostnames, name+"."+suffix)
+		}
+	}
+	if pod.Spec.NodeName != "" {
+		hostnames = append(hostnames, pod.Spec.NodeName)
+	}
+	if pod.Status.PodIP != "" {
+		hostnames = append(hostnames, pod.Status.PodIP)
+	}
+	return append(hostnames, loopbackHostnames...)
+}
+
+// recordEvent records an event on object when the signer has a recorder.
+func (r *EtcdCertSigner) recordEvent(object runtime.Object, eventtype string, reason string, message string) {
+	if r.recorder != nil {
+		r.recorder.Event(object, eventtype, reason, message)
+	}
+}
+
+// recordCertificateEvent records an event on secret and on the owner of req.
+func (r *EtcdCertSigner) recordCertificateEvent(secret *corev1.Secret, req *certificateRequest, eventtype string, reason string, message string) {
+	r.recordEvent(secret, eventtype, reason, message)
+	if req.owner != nil {
+		r.recordEvent(req.owner, eventtype, reason, message)
+	}
+}
+
+// recordWarning records err as a warning event on each of objects, and counts it as a signing failure.
+func (r *EtcdCertSigner) recordWarning(reason string, err error, objects ...runtime.Object) {
+	signingFailures.WithLabelValues(reason).Inc()
+	for _, object := range objects {
+		r.recordEvent(object, corev1.EventTypeWarning, reason, err.Error())
+	}
+}
+
 func (r EtcdCertSigner) getSecret(name string, namespace string) (*corev1.Secret, error) {
 	secret := &corev1.Secret{}
 	if err := r.client.Get(context.Background(), types.NamespacedName{Namespace: namespace, Name: name}, secret); err != nil {
@@ -306,30 +740,81 @@ func (r *EtcdCertSigner) getConfigMap(name string, namespace string) (*corev1.Co
 	return cm, nil
 }
 
-func (r *EtcdCertSigner) populateSecret(secret *corev1.Secret, cert *bytes.Buffer, key *bytes.Buffer) error {
-	//TODO: Update annotations Not Before and Not After for Cert Rotation
+func (r *EtcdCertSigner) populateSecret(secret *corev1.Secret, cert *bytes.Buffer, key *bytes.Buffer, root []byte) error {
+	c, err := parseCertificate(cert.Bytes())
+	if err != nil {
+		return err
+	}
 	d := make(map[string][]byte)
 	d["tls.crt"] = cert.Bytes()
 	d["tls.key"] = key.Bytes()
+	d[CARootKey] = root
 	secret.Data = d
+
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	secret.Annotations[CertificateNotBeforeAnnotation] = c.NotBefore.Format(time.RFC3339)
+	secret.Annotations[CertificateNotAfterAnnotation] = c.NotAfter.Format(time.RFC3339)
+	secret.Annotations[CertificateIssuer] = c.Issuer.CommonName
+	delete(secret.Annotations, CertificateUntrustedSinceAnnotation)
 	return r.client.Update(context.Background(), secret)
 }
 
-func etcdPod(labels map[string]string) bool {
-	if l, ok := labels["k8s-app"]; ok && l == "etcd" {
-		return true
+// getRenewalTimeBefore returns the time renewBefore the expiry of the certificate in secret, or
+// the time after which it should be re-signed when renewBefore is zero. The validity is read from
+// the NotBefore/NotAfter annotations, falling back to the certificate itself for secrets populated
+// before the annotations were recorded.
+func getRenewalTimeBefore(secret *corev1.Secret, renewBefore time.Duration) (time.Time, error) {
+	certPEM, ok := secret.Data["tls.crt"]
+	if !ok {
+		return time.Time{}, errors.NewBadRequest("Certificate not found")
 	}
-	return false
+	notBefore, errBefore := time.Parse(time.RFC3339, secret.GetAnnotations()[CertificateNotBeforeAnnotation])
+	notAfter, errAfter := time.Parse(time.RFC3339, secret.GetAnnotations()[CertificateNotAfterAnnotation])
+	if errBefore != nil || errAfter != nil {
+		c, err := parseCertificate(certPEM)
+		if err != nil {
+			return time.Time{}, err
+		}
+		notBefore, notAfter = c.NotBefore, c.NotAfter
+	}
+	if renewBefore > 0 {
+		return notAfter.Add(-renewBefore), nil
+	}
+	lifetime := notAfter.Sub(notBefore)
+	return notBefore.Add(time.Duration(float64(lifetime) * EtcdCertRenewalRatio)), nil
 }
 
-func getPeerSecretName(p *corev1.Pod) string {
-	return p.Name + "-peer"
+// requeueAfter returns the duration from now until the earliest of the renewal times.
+func requeueAfter(now time.Time, renewals ...time.Time) time.Duration {
+	var next time.Duration
+	for i, renewal := range renewals {
+		if d := renewal.Sub(now); i == 0 || d < next {
+			next = d
+		}
+	}
+	if next <= 0 {
+		// Renewal is already due, requeue immediately rather than disabling the requeue
+		return time.Second
+	}
+	return next
 }
 
-func getServerSecretName(p *corev1.Pod) string {
-	return p.Name + "-server"
+// parseCertificate parses the first PEM encoded certificate in certPEM.
+func parseCertificate(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.NewBadRequest("Unable to decode certificate PEM")
+	}
+	return x509.ParseCertificate(block.Bytes)
 }
 
-func getMetricsSecretName(p *corev1.Pod) string {
-	return p.Name + "-metrics"
+// memberSecret is a secret holding a certificate of an etcd member.
+type memberSecret struct {
+	name string
+	// role is the role of the certificate in the default etcd identity.
+	role string
+	// profile is the signing profile used when the secret does not annotate one.
+	profile string
 }
@@ -8,55 +8,130 @@ import (
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"fmt"
+	etcdv1alpha1 "github.com/alaypatel07/etcd-cert-signer/pkg/apis/etcd/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
 	"math"
 	"math/big"
 	"reflect"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 )
 
-func Test_etcdPod(t *testing.T) {
-	type args struct {
+func TestEtcdCertSigner_etcdPodPredicate(t *testing.T) {
+	config := &etcdv1alpha1.EtcdCertSignerConfig{
+		ObjectMeta: v1.ObjectMeta{Name: etcdv1alpha1.EtcdCertSignerConfigName},
+		Spec: etcdv1alpha1.EtcdCertSignerConfigSpec{
+			PodSelector: &v1.LabelSelector{
+				MatchLabels: map[string]string{"app.kubernetes.io/name": "etcd"},
+				MatchExpressions: []v1.LabelSelectorRequirement{
+					{Key: "etcd-cluster", Operator: v1.LabelSelectorOpIn, Values: []string{"main", "events"}},
+				},
+			},
+		},
+	}
+	p := (&EtcdCertSigner{client: fake.NewFakeClient(config)}).etcdPodPredicate()
+
+	tests := []struct {
+		name   string
 		labels map[string]string
+		want   bool
+	}{
+		{
+			name:   "Member of a selected cluster",
+			labels: map[string]string{"app.kubernetes.io/name": "etcd", "etcd-cluster": "events"},
+			want:   true,
+		},
+		{
+			name:   "Member of another cluster",
+			labels: map[string]string{"app.kubernetes.io/name": "etcd", "etcd-cluster": "backup"},
+		},
+		{
+			name:   "Pod without a cluster",
+			labels: map[string]string{"app.kubernetes.io/name": "etcd"},
+		},
+		{
+			name:   "Pod with the default labels",
+			labels: map[string]string{"k8s-app": "etcd"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "etcd-0", Namespace: "etcd-namespace", Labels: tt.labels}}
+			if got := p.Create(event.CreateEvent{Meta: pod, Object: pod}); got != tt.want {
+				t.Errorf("Create() = %v, want %v", got, tt.want)
+			}
+			if got := p.Update(event.UpdateEvent{MetaOld: pod, ObjectOld: pod, MetaNew: pod, ObjectNew: pod}); got != tt.want {
+				t.Errorf("Update() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEtcdCertSigner_getSecretRequests(t *testing.T) {
+	newPod := func(name string, labels map[string]string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: name, Namespace: "etcd-namespace", Labels: labels}}
 	}
+	r := &EtcdCertSigner{client: fake.NewFakeClient(
+		newPod("etcd-0", map[string]string{"k8s-app": "etcd"}),
+		newPod("etcd-1", map[string]string{"k8s-app": "etcd"}),
+		newPod("web-0", map[string]string{"app": "web"}),
+	)}
+
 	tests := []struct {
-		name string
-		args args
-		want bool
+		name      string
+		secret    v1.ObjectMeta
+		wantNames []string
 	}{
 		{
-			name: "Etcd pod",
-			args: args{labels: map[string]string{
-				"k8s-app": "etcd",
-			}},
-			want: true,
+			name:      "Member secret named after its pod",
+			secret:    v1.ObjectMeta{Name: "etcd-0-peer", Namespace: "etcd-namespace"},
+			wantNames: []string{"etcd-0"},
 		},
 		{
-			name: "Etcd pod with other labels",
-			args: args{labels: map[string]string{
-				"k8s-app": "etcd",
-				"foo":     "bar",
-				"_":       "_",
-			}},
-			want: true,
+			name:      "Member secret labelled with its pod",
+			secret:    v1.ObjectMeta{Name: "etcd-1-tls", Namespace: "etcd-namespace", Labels: map[string]string{CertificateMemberLabel: "etcd-1"}},
+			wantNames: []string{"etcd-1"},
 		},
 		{
-			name: "Not an etcd pod",
-			args: args{labels: map[string]string{
-				"not-k8s-app": "etcd",
-				"foo":         "bar",
-			}},
-			want: false,
+			name:   "Secret named after a pod that is not an etcd member",
+			secret: v1.ObjectMeta{Name: "web-0-peer", Namespace: "etcd-namespace"},
+		},
+		{
+			name:      "CA secret",
+			secret:    v1.ObjectMeta{Name: etcdCASecretName, Namespace: etcdCASecretNamespace},
+			wantNames: []string{"etcd-0", "etcd-1"},
+		},
+		{
+			name:      "CA secret replacing the metrics CA",
+			secret:    v1.ObjectMeta{Name: getNextCASecretName(etcdMetricCASecretName), Namespace: etcdCASecretNamespace},
+			wantNames: []string{"etcd-0", "etcd-1"},
+		},
+		{
+			name:   "Other secret of the CA namespace",
+			secret: v1.ObjectMeta{Name: "builder-token", Namespace: etcdCASecretNamespace},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := etcdPod(tt.args.labels); got != tt.want {
-				t.Errorf("etcdPod() = %v, want %v", got, tt.want)
+			secret := &corev1.Secret{ObjectMeta: tt.secret}
+			var names []string
+			for _, req := range r.getSecretRequests(handler.MapObject{Meta: secret, Object: secret}) {
+				names = append(names, req.Name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("getSecretRequests() = %v, want %v", names, tt.wantNames)
 			}
 		})
 	}
@@ -229,9 +304,13 @@ func Test_getCerts(t *testing.T) {
 	type args struct {
 		etcdCASecret *corev1.Secret
 		targetSecret *corev1.Secret
-		org          string
+		profile      *signingProfile
+		hostnames    []string
+		identity     string
 	}
 
+	peerProfile := defaultProfiles(defaultOperatorSettings())[PeerProfile]
+
 	validArgs := args{
 		etcdCASecret: &corev1.Secret{
 			TypeMeta: v1.TypeMeta{
@@ -264,7 +343,9 @@ func Test_getCerts(t *testing.T) {
 			},
 			Type: corev1.SecretTypeTLS,
 		},
-		org: "system:peers",
+		profile:   &peerProfile,
+		hostnames: []string{"localhost", "etcd-0.etcd.test", "*.etcd.test", "10.10.10.10"},
+		identity:  "system:peer:etcd-0.etcd.test",
 	}
 	tests := []struct {
 		name    string
@@ -281,7 +362,7 @@ func Test_getCerts(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, got1, err := getCerts(tt.args.etcdCASecret, tt.args.targetSecret, tt.args.org)
+			got, got1, err := getCerts(tt.args.etcdCASecret, tt.args.targetSecret, tt.args.profile, tt.args.hostnames, tt.args.identity)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("getCerts() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -304,3 +385,334 @@ func Test_getCerts(t *testing.T) {
 		})
 	}
 }
+
+func Test_getRenewalTimeBefore(t *testing.T) {
+	notBefore := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := notBefore.Add(10 * 24 * time.Hour)
+
+	type args struct {
+		secret      *corev1.Secret
+		renewBefore time.Duration
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    time.Time
+		wantErr bool
+	}{
+		{
+			name: "Renewal at 80% of the annotated lifetime",
+			args: args{secret: &corev1.Secret{
+				ObjectMeta: v1.ObjectMeta{
+					Annotations: map[string]string{
+						CertificateNotBeforeAnnotation: notBefore.Format(time.RFC3339),
+						CertificateNotAfterAnnotation:  notAfter.Format(time.RFC3339),
+					},
+				},
+				Data: map[string][]byte{"tls.crt": []byte("cert")},
+			}},
+			want:    notBefore.Add(8 * 24 * time.Hour),
+			wantErr: false,
+		},
+		{
+			name: "Renewal before the annotated expiry",
+			args: args{secret: &corev1.Secret{
+				ObjectMeta: v1.ObjectMeta{
+					Annotations: map[string]string{
+						CertificateNotBeforeAnnotation: notBefore.Format(time.RFC3339),
+						CertificateNotAfterAnnotation:  notAfter.Format(time.RFC3339),
+					},
+				},
+				Data: map[string][]byte{"tls.crt": []byte("cert")},
+			}, renewBefore: 24 * time.Hour},
+			want: notAfter.Add(-24 * time.Hour),
+		},
+		{
+			name: "Certificate not populated",
+			args: args{secret: &corev1.Secret{
+				ObjectMeta: v1.ObjectMeta{
+					Annotations: map[string]string{
+						CertificateNotBeforeAnnotation: notBefore.Format(time.RFC3339),
+						CertificateNotAfterAnnotation:  notAfter.Format(time.RFC3339),
+					},
+				},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "Annotations missing and certificate unparseable",
+			args: args{secret: &corev1.Secret{
+				Data: map[string][]byte{"tls.crt": []byte("cert")},
+			}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getRenewalTimeBefore(tt.args.secret, tt.args.renewBefore)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getRenewalTimeBefore() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("getRenewalTimeBefore() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_requeueAfter(t *testing.T) {
+	now := time.Now()
+
+	type args struct {
+		renewals []time.Time
+	}
+	tests := []struct {
+		name string
+		args args
+		want time.Duration
+	}{
+		{
+			name: "Earliest renewal wins",
+			args: args{renewals: []time.Time{now.Add(2 * time.Hour), now.Add(time.Hour)}},
+			want: time.Hour,
+		},
+		{
+			name: "Renewal already due",
+			args: args{renewals: []time.Time{now.Add(-time.Hour), now.Add(time.Hour)}},
+			want: time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := requeueAfter(now, tt.args.renewals...); got != tt.want {
+				t.Errorf("requeueAfter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_getHostnames(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "etcd-0",
+			Namespace: "etcd-namespace",
+		},
+		Spec: corev1.PodSpec{
+			Hostname: "etcd-0",
+			NodeName: "master-0",
+		},
+		Status: corev1.PodStatus{
+			PodIP: "10.0.0.1",
+		},
+	}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		dnsSuffixes []string
+		want        []string
+	}{
+		{
+			name: "Hostnames derived from the pod",
+			want: []string{"etcd-0", "master-0", "10.0.0.1", "localhost", "127.0.0.1"},
+		},
+		{
+			name:        "Hostnames with DNS suffixes",
+			dnsSuffixes: []string{"etcd.test", "etcd.svc"},
+			want:        []string{"etcd-0", "etcd-0.etcd.test", "etcd-0.etcd.svc", "master-0", "10.0.0.1", "localhost", "127.0.0.1"},
+		},
+		{
+			name:        "Annotated hostnames come first",
+			annotations: map[string]string{CertificateHostnames: "etcd-0.etcd.test,localhost"},
+			dnsSuffixes: []string{"etcd.test"},
+			want:        []string{"etcd-0.etcd.test", "localhost", "etcd-0", "master-0", "10.0.0.1", "127.0.0.1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := &corev1.Secret{ObjectMeta: v1.ObjectMeta{Annotations: tt.annotations}}
+			if got := getHostnames(pod, secret, tt.dnsSuffixes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getHostnames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEtcdCertSigner_Reconcile_waitForPodIP(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "etcd-0",
+			Namespace: "etcd-namespace",
+			Labels:    map[string]string{"k8s-app": "etcd"},
+			UID:       "pod-uid",
+		},
+	}
+	r := &EtcdCertSigner{client: fake.NewFakeClient(pod), scheme: scheme.Scheme}
+	result, err := r.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}})
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if result.RequeueAfter != podIPRequeuePeriod {
+		t.Errorf("Reconcile() RequeueAfter = %v, want %v", result.RequeueAfter, podIPRequeuePeriod)
+	}
+
+	// The member secrets must exist for the pod to start and get its IP
+	for _, member := range defaultOperatorSettings().getMemberSecrets(pod) {
+		secret, err := r.getSecret(member.name, pod.Namespace)
+		if err != nil {
+			t.Fatalf("Reconcile() did not create secret %s: %v", member.name, err)
+		}
+		if secret.Type != corev1.SecretTypeTLS || secret.Labels[CertificateMemberLabel] != pod.Name || secret.Labels[CertificateRoleLabel] != member.role {
+			t.Errorf("Reconcile() secret %s type = %v, labels = %v", member.name, secret.Type, secret.Labels)
+		}
+		if secret.Annotations[CertificateProfile] != member.profile {
+			t.Errorf("Reconcile() secret %s profile = %v, want %v", member.name, secret.Annotations[CertificateProfile], member.profile)
+		}
+		// The member secrets of a pod without a StatefulSet are deleted with it by default
+		if controller := v1.GetControllerOf(secret); controller == nil || controller.Kind != "Pod" || controller.UID != pod.UID {
+			t.Errorf("Reconcile() secret %s owners = %v, want the pod", member.name, secret.OwnerReferences)
+		}
+		// The identity is derived from the hostnames when signing
+		if identity, ok := secret.Annotations[CertificateEtcdIdentity]; ok {
+			t.Errorf("Reconcile() secret %s etcd identity = %v, want none", member.name, identity)
+		}
+	}
+}
+
+func TestEtcdCertSigner_Reconcile_events(t *testing.T) {
+	tests := []struct {
+		name       string
+		objs       []runtime.Object
+		wantEvents []string
+	}{
+		{
+			name: "Signed member",
+			objs: []runtime.Object{newTestCASecret(t, etcdCASecretName, etcdCASecretNamespace), newTestCASecret(t, etcdMetricCASecretName, etcdCASecretNamespace)},
+			// The peer, server and metrics certificates on their secret and on the pod
+			wantEvents: []string{"Normal CertificateIssued", "Normal CertificateIssued", "Normal CertificateIssued",
+				"Normal CertificateIssued", "Normal CertificateIssued", "Normal CertificateIssued"},
+		},
+		{
+			name:       "Missing CA",
+			wantEvents: []string{"Warning CANotFound"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: v1.ObjectMeta{
+					Name:      "etcd-0",
+					Namespace: "etcd-namespace",
+					Labels:    map[string]string{"k8s-app": "etcd"},
+				},
+				Status: corev1.PodStatus{PodIP: "10.0.0.1"},
+			}
+			recorder := record.NewFakeRecorder(10)
+			r := &EtcdCertSigner{client: fake.NewFakeClient(append(tt.objs, pod)...), scheme: scheme.Scheme, recorder: recorder}
+			_, _ = r.Reconcile(reconcile.Request{NamespacedName: types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}})
+
+			close(recorder.Events)
+			var events []string
+			for event := range recorder.Events {
+				events = append(events, event)
+			}
+			if len(events) != len(tt.wantEvents) {
+				t.Fatalf("Reconcile() events = %q, want %q", events, tt.wantEvents)
+			}
+			for i := range events {
+				if !strings.HasPrefix(events[i], tt.wantEvents[i]) {
+					t.Errorf("Reconcile() event = %q, want %q", events[i], tt.wantEvents[i])
+				}
+			}
+		})
+	}
+}
+
+func Test_getEtcdIdentity(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{Name: "etcd-0"}}
+	tests := []struct {
+		name      string
+		role      string
+		identity  string
+		annotated string
+		want      string
+		wantErr   bool
+	}{
+		{name: "Default peer identity", role: "peer", want: "system:peer:etcd-0"},
+		{name: "Default metrics identity", role: "metrics", want: "system:metrics:etcd-0"},
+		{name: "Default identity with annotated loopback hostnames", role: "server", annotated: "localhost,etcd-0.etcd.test", want: "system:server:etcd-0"},
+		{name: "Annotated identity", role: "server", identity: "system:server:etcd-0.etcd.test", want: "system:server:etcd-0.etcd.test"},
+		{name: "Annotated identity of another role", role: "server", identity: "system:peer:etcd-0", wantErr: true},
+		{name: "Annotated identity of another host", role: "peer", identity: "system:peer:etcd-1", wantErr: true},
+		{name: "Annotated loopback identity", role: "peer", identity: "system:peer:localhost", wantErr: true},
+		{name: "Annotated loopback IP identity", role: "peer", identity: "system:peer:127.0.0.1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := &corev1.Secret{}
+			if tt.annotated != "" {
+				secret.Annotations = map[string]string{CertificateHostnames: tt.annotated}
+			}
+			hostnames := getHostnames(pod, secret, []string{"etcd.test"})
+			got, err := getEtcdIdentity(tt.identity, tt.role, getPodHostname(pod), hostnames)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getEtcdIdentity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("getEtcdIdentity() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEtcdCertSigner_ensureCertificate_replacedCA(t *testing.T) {
+	const namespace = "etcd-namespace"
+	oldCA, newCA := newTestCASecret(t, etcdCASecretName, namespace), newTestCASecret(t, etcdCASecretName, namespace)
+	peerProfile := defaultProfiles(defaultOperatorSettings())[PeerProfile]
+	hostnames := []string{"etcd-0"}
+
+	tests := []struct {
+		name        string
+		gracePeriod time.Duration
+		// untrustedSince is when the certificate was found to not chain to the CA, if it was
+		untrustedSince time.Duration
+		wantReissued   bool
+	}{
+		{name: "No grace period", wantReissued: true},
+		{name: "Within the grace period", gracePeriod: time.Hour},
+		{name: "Grace period passed", gracePeriod: time.Hour, untrustedSince: 2 * time.Hour, wantReissued: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := &corev1.Secret{
+				ObjectMeta: v1.ObjectMeta{
+					Name:      "etcd-0-peer",
+					Namespace: namespace,
+				},
+			}
+			r := &EtcdCertSigner{client: fake.NewFakeClient(secret)}
+			if _, err := r.ensureCertificate(oldCA, secret, &certificateRequest{profile: &peerProfile, hostnames: hostnames, identity: "system:peer:etcd-0"}); err != nil {
+				t.Fatalf("ensureCertificate() error = %v", err)
+			}
+			if tt.untrustedSince > 0 {
+				secret.Annotations[CertificateUntrustedSinceAnnotation] = time.Now().Add(-tt.untrustedSince).Format(time.RFC3339)
+			}
+
+			next, err := r.ensureCertificate(newCA, secret, &certificateRequest{profile: &peerProfile, hostnames: hostnames, identity: "system:peer:etcd-0", gracePeriod: tt.gracePeriod})
+			if err != nil {
+				t.Fatalf("ensureCertificate() error = %v", err)
+			}
+			reissued := verifyIssuedBy(secret, newCA) == nil
+			if reissued != tt.wantReissued {
+				t.Fatalf("ensureCertificate() reissued = %v, want %v", reissued, tt.wantReissued)
+			}
+			_, untrusted := secret.Annotations[CertificateUntrustedSinceAnnotation]
+			if untrusted == reissued {
+				t.Errorf("ensureCertificate() %s = %v, want %v", CertificateUntrustedSinceAnnotation, untrusted, !reissued)
+			}
+			if !reissued && next.After(time.Now().Add(tt.gracePeriod)) {
+				t.Errorf("ensureCertificate() next = %v, want the end of the grace period", next)
+			}
+		})
+	}
+}
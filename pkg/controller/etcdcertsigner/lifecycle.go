@@ -0,0 +1,218 @@
+package etcdcertsigner
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+
+	etcdv1alpha1 "github.com/alaypatel07/etcd-cert-signer/pkg/apis/etcd/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// MemberSecretPolicyAnnotation overrides the member secret policy of the EtcdCertSignerConfig for
+// the annotated pod, e.g. Retain for a static member.
+const MemberSecretPolicyAnnotation = "auth.openshift.io/member-secret-policy"
+
+// memberSecretsFinalizer holds the deletion of the etcd pods with the Revoke member secret policy
+// until the certificates of their member secrets are revoked.
+const memberSecretsFinalizer = "auth.openshift.io/etcd-member-secrets"
+
+// getMemberSecretOwner returns the controller reference of the member secrets of pod under policy,
+// or nil when they are retained. Revoked member secrets are owned like with DeleteWithStatefulSet,
+// the finalizer revokes them before they are garbage collected.
+func (r *EtcdCertSigner) getMemberSecretOwner(pod *corev1.Pod, policy etcdv1alpha1.MemberSecretPolicy) (*metav1.OwnerReference, error) {
+	podRef := metav1.NewControllerRef(pod, corev1.SchemeGroupVersion.WithKind("Pod"))
+	switch policy {
+	case etcdv1alpha1.MemberSecretPolicyDeleteWithPod:
+		return podRef, nil
+	case etcdv1alpha1.MemberSecretPolicyDeleteWithStatefulSet, etcdv1alpha1.MemberSecretPolicyRevoke:
+		statefulSet, err := r.getPodStatefulSet(pod)
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+		if statefulSet == nil {
+			return podRef, nil
+		}
+		return metav1.NewControllerRef(statefulSet, appsv1.SchemeGroupVersion.WithKind("StatefulSet")), nil
+	}
+	return nil, nil
+}
+
+// ensureMemberSecretOwner makes owner the controller of secret in place of the pod or StatefulSet
+// set for another policy, or removes that controller when owner is nil. Secrets controlled by
+// anything else are left alone.
+func (r *EtcdCertSigner) ensureMemberSecretOwner(secret *corev1.Secret, owner *metav1.OwnerReference) error {
+	if controller := metav1.GetControllerOf(secret); controller != nil && !isMemberSecretOwner(controller) {
+		return nil
+	}
+	var refs []metav1.OwnerReference
+	for i := range secret.OwnerReferences {
+		if !isMemberSecretOwner(&secret.OwnerReferences[i]) {
+			refs = append(refs, secret.OwnerReferences[i])
+		}
+	}
+	if owner != nil {
+		refs = append(refs, *owner)
+	}
+	if len(refs) == len(secret.OwnerReferences) && (len(refs) == 0 || reflect.DeepEqual(refs, secret.OwnerReferences)) {
+		return nil
+	}
+
+	log.Info("Updating member secret owner", "Secret.Namespace", secret.Namespace, "Secret.Name", secret.Name)
+	secret.OwnerReferences = refs
+	return r.client.Update(context.TODO(), secret)
+}
+
+// isMemberSecretOwner returns whether ref is a controller reference set by a member secret policy.
+func isMemberSecretOwner(ref *metav1.OwnerReference) bool {
+	if ref.Controller == nil || !*ref.Controller {
+		return false
+	}
+	return (ref.APIVersion == corev1.SchemeGroupVersion.String() && ref.Kind == "Pod") ||
+		(ref.APIVersion == appsv1.SchemeGroupVersion.String() && ref.Kind == "StatefulSet")
+}
+
+// ensureMemberFinalizer adds the member secrets finalizer to pod when policy revokes its member
+// secrets, and removes it otherwise.
+func (r *EtcdCertSigner) ensureMemberFinalizer(pod *corev1.Pod, policy etcdv1alpha1.MemberSecretPolicy) error {
+	want := policy == etcdv1alpha1.MemberSecretPolicyRevoke
+	if hasMemberFinalizer(pod) == want {
+		return nil
+	}
+	if want {
+		pod.Finalizers = append(pod.Finalizers, memberSecretsFinalizer)
+	} else {
+		removeMemberFinalizer(pod)
+	}
+	return r.client.Update(context.TODO(), pod)
+}
+
+// finalizeMember revokes the certificates of the member secrets of the deleted pod and deletes
+// them when the member is permanently removed, then releases the pod.
+func (r *EtcdCertSigner) finalizeMember(pod *corev1.Pod, config *signerConfig) error {
+	if !hasMemberFinalizer(pod) {
+		return nil
+	}
+	policy, err := config.Settings.getMemberSecretPolicy(pod)
+	if err != nil {
+		return err
+	}
+	if policy == etcdv1alpha1.MemberSecretPolicyRevoke {
+		removed, err := r.isMemberRemoved(pod)
+		if err != nil {
+			return err
+		}
+		if removed {
+			if err := r.revokeMemberSecrets(pod, config); err != nil {
+				return err
+			}
+			r.recordEvent(pod, corev1.EventTypeNormal, "MemberSecretsRevoked", "Revoked and deleted the member secrets of the removed member")
+		}
+	}
+	removeMemberFinalizer(pod)
+	return r.client.Update(context.TODO(), pod)
+}
+
+// revokeMemberSecrets revokes the certificates held by the member secrets of pod, deletes the
+// secrets and publishes the CRLs of their CAs.
+func (r *EtcdCertSigner) revokeMemberSecrets(pod *corev1.Pod, config *signerConfig) error {
+	caSecretNames := sets.NewString()
+	for _, member := range config.Settings.getMemberSecrets(pod) {
+		secret, err := r.getSecret(member.name, pod.Namespace)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		profile, err := config.getProfile(getProfileName(secret, member.profile))
+		if err != nil {
+			return err
+		}
+		if err := r.revokeCertificate(profile.CASecretName, config.Settings.CASecretNamespace, secret); err != nil {
+			return err
+		}
+		caSecretNames.Insert(profile.CASecretName)
+
+		log.Info("Deleting member secret", "Secret.Namespace", secret.Namespace, "Secret.Name", secret.Name)
+		if err := r.client.Delete(context.TODO(), secret); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	// Publish the revocations without waiting for the next reconcile of another member
+	for _, name := range caSecretNames.List() {
+		ca, _, err := r.getCASecret(name, config.Settings.CASecretNamespace, config)
+		if err != nil {
+			return err
+		}
+		if _, err := r.ensureCRL(ca, name, config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isMemberRemoved returns whether the deleted pod will not be recreated, i.e. it does not belong
+// to a StatefulSet, or its StatefulSet is deleted or was scaled down below its ordinal.
+func (r *EtcdCertSigner) isMemberRemoved(pod *corev1.Pod) (bool, error) {
+	statefulSet, err := r.getPodStatefulSet(pod)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	if statefulSet == nil || statefulSet.DeletionTimestamp != nil {
+		return true, nil
+	}
+	replicas := int32(1)
+	if statefulSet.Spec.Replicas != nil {
+		replicas = *statefulSet.Spec.Replicas
+	}
+	ordinal, err := strconv.Atoi(strings.TrimPrefix(pod.Name, statefulSet.Name+"-"))
+	if err != nil {
+		return true, nil
+	}
+	return int32(ordinal) >= replicas, nil
+}
+
+// getPodStatefulSet returns the StatefulSet controlling pod, or nil when it has none. A NotFound
+// error is returned when the StatefulSet is gone.
+func (r *EtcdCertSigner) getPodStatefulSet(pod *corev1.Pod) (*appsv1.StatefulSet, error) {
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil || ref.Kind != "StatefulSet" {
+		return nil, nil
+	}
+	statefulSet := &appsv1.StatefulSet{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: pod.Namespace, Name: ref.Name}, statefulSet)
+	if err != nil {
+		return nil, err
+	}
+	if statefulSet.UID != ref.UID {
+		// Recreated with the same name, the pod belonged to the previous one
+		return nil, errors.NewNotFound(schema.GroupResource{Group: appsv1.GroupName, Resource: "statefulsets"}, ref.Name)
+	}
+	return statefulSet, nil
+}
+
+func hasMemberFinalizer(pod *corev1.Pod) bool {
+	return sets.NewString(pod.Finalizers...).Has(memberSecretsFinalizer)
+}
+
+func removeMemberFinalizer(pod *corev1.Pod) {
+	var finalizers []string
+	for _, f := range pod.Finalizers {
+		if f != memberSecretsFinalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	pod.Finalizers = finalizers
+}
@@ -0,0 +1,183 @@
+package etcdcertsigner
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	etcdv1alpha1 "github.com/alaypatel07/etcd-cert-signer/pkg/apis/etcd/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestEtcdCertSigner_finalizeMember(t *testing.T) {
+	const namespace = "etcd-namespace"
+	caSecret := newTestCASecret(t, etcdCASecretName, etcdCASecretNamespace)
+	ca, err := loadSigningCA(caSecret.Data["tls.crt"], caSecret.Data["tls.key"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafPEM, leafKeyPEM, err := ca.makeServerCert([]string{"etcd-0"}, time.Hour, KeyAlgorithmECDSAP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := parseTestCert(t, leafPEM.Bytes()).SerialNumber.Text(16)
+
+	statefulSet := func(replicas int32) *appsv1.StatefulSet {
+		return &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "etcd", Namespace: namespace, UID: "etcd-uid"},
+			Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+		}
+	}
+	now := metav1.Now()
+	tests := []struct {
+		name        string
+		policy      etcdv1alpha1.MemberSecretPolicy
+		statefulSet *appsv1.StatefulSet
+		wantRevoked bool
+	}{
+		{name: "Removed member", policy: etcdv1alpha1.MemberSecretPolicyRevoke, wantRevoked: true},
+		{name: "Restarted StatefulSet member", policy: etcdv1alpha1.MemberSecretPolicyRevoke, statefulSet: statefulSet(3)},
+		{name: "Scaled down StatefulSet member", policy: etcdv1alpha1.MemberSecretPolicyRevoke, statefulSet: statefulSet(0), wantRevoked: true},
+		{name: "Retained member", policy: etcdv1alpha1.MemberSecretPolicyRetain},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "etcd-0",
+					Namespace:         namespace,
+					Labels:            map[string]string{"k8s-app": "etcd"},
+					Annotations:       map[string]string{MemberSecretPolicyAnnotation: string(tt.policy)},
+					Finalizers:        []string{memberSecretsFinalizer},
+					DeletionTimestamp: &now,
+				},
+			}
+			objs := []runtime.Object{pod, caSecret.DeepCopy()}
+			if tt.statefulSet != nil {
+				pod.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(tt.statefulSet, appsv1.SchemeGroupVersion.WithKind("StatefulSet"))}
+				objs = append(objs, tt.statefulSet)
+			}
+			// The other member secrets do not exist
+			peerSecret := newTLSSecret("etcd-0-peer", namespace)
+			peerSecret.Data = map[string][]byte{"tls.crt": leafPEM.Bytes(), "tls.key": leafKeyPEM.Bytes()}
+			objs = append(objs, peerSecret)
+			r := &EtcdCertSigner{client: fake.NewFakeClient(objs...)}
+
+			if err := r.finalizeMember(pod, defaultSignerConfig()); err != nil {
+				t.Fatalf("finalizeMember() error = %v", err)
+			}
+			got := &corev1.Pod{}
+			if err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: pod.Name}, got); err != nil {
+				t.Fatal(err)
+			}
+			if hasMemberFinalizer(got) {
+				t.Errorf("finalizeMember() did not remove the finalizer")
+			}
+			if _, err := r.getSecret(peerSecret.Name, namespace); errors.IsNotFound(err) != tt.wantRevoked {
+				t.Errorf("finalizeMember() secret deleted = %v, want %v", errors.IsNotFound(err), tt.wantRevoked)
+			}
+			records, err := r.getCertificateRecords(getRevokedCertificatesName(etcdCASecretName), etcdCASecretNamespace, revokedCertificatesKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if revoked := len(records) == 1 && records[0].SerialNumber == serial; revoked != tt.wantRevoked {
+				t.Errorf("finalizeMember() revoked certificates = %v, want %v revoked %v", records, serial, tt.wantRevoked)
+			}
+		})
+	}
+}
+
+func TestEtcdCertSigner_getMemberSecretOwner(t *testing.T) {
+	statefulSet := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "etcd", Namespace: "etcd-namespace", UID: "etcd-uid"}}
+	tests := []struct {
+		name           string
+		policy         etcdv1alpha1.MemberSecretPolicy
+		hasStatefulSet bool
+		wantUID        types.UID
+	}{
+		{name: "Default policy", wantUID: "pod-uid"},
+		{name: "Default policy with a StatefulSet", hasStatefulSet: true, wantUID: statefulSet.UID},
+		{name: "Retain", policy: etcdv1alpha1.MemberSecretPolicyRetain},
+		{name: "Delete with pod", policy: etcdv1alpha1.MemberSecretPolicyDeleteWithPod, wantUID: "pod-uid"},
+		{name: "Delete with StatefulSet", policy: etcdv1alpha1.MemberSecretPolicyDeleteWithStatefulSet, hasStatefulSet: true, wantUID: statefulSet.UID},
+		{name: "Delete with StatefulSet of a pod without one", policy: etcdv1alpha1.MemberSecretPolicyDeleteWithStatefulSet, wantUID: "pod-uid"},
+		{name: "Revoke", policy: etcdv1alpha1.MemberSecretPolicyRevoke, hasStatefulSet: true, wantUID: statefulSet.UID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "etcd-0", Namespace: "etcd-namespace", UID: "pod-uid"}}
+			if tt.policy != "" {
+				pod.Annotations = map[string]string{MemberSecretPolicyAnnotation: string(tt.policy)}
+			}
+			objs := []runtime.Object{pod}
+			if tt.hasStatefulSet {
+				pod.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(statefulSet, appsv1.SchemeGroupVersion.WithKind("StatefulSet"))}
+				objs = append(objs, statefulSet)
+			}
+			r := &EtcdCertSigner{client: fake.NewFakeClient(objs...)}
+			policy, err := defaultOperatorSettings().getMemberSecretPolicy(pod)
+			if err != nil {
+				t.Fatalf("getMemberSecretPolicy() error = %v", err)
+			}
+			owner, err := r.getMemberSecretOwner(pod, policy)
+			if err != nil {
+				t.Fatalf("getMemberSecretOwner() error = %v", err)
+			}
+			var gotUID types.UID
+			if owner != nil {
+				gotUID = owner.UID
+			}
+			if gotUID != tt.wantUID {
+				t.Errorf("getMemberSecretOwner() = %v, want owner %q", owner, tt.wantUID)
+			}
+		})
+	}
+}
+
+func TestEtcdCertSigner_ensureMemberSecretOwner(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "etcd-0", Namespace: "etcd-namespace", UID: "pod-uid"}}
+	statefulSet := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "etcd", Namespace: "etcd-namespace", UID: "etcd-uid"}}
+	other := &etcdv1alpha1.EtcdCertificate{ObjectMeta: metav1.ObjectMeta{Name: "etcd-0", Namespace: "etcd-namespace", UID: "other-uid"}}
+	podRef := metav1.NewControllerRef(pod, corev1.SchemeGroupVersion.WithKind("Pod"))
+	statefulSetRef := metav1.NewControllerRef(statefulSet, appsv1.SchemeGroupVersion.WithKind("StatefulSet"))
+	otherRef := metav1.NewControllerRef(other, etcdv1alpha1.SchemeGroupVersion.WithKind("EtcdCertificate"))
+
+	tests := []struct {
+		name    string
+		refs    []metav1.OwnerReference
+		owner   *metav1.OwnerReference
+		wantUID types.UID
+	}{
+		{name: "Unowned secret", owner: podRef, wantUID: pod.UID},
+		{name: "Secret moved to the StatefulSet", refs: []metav1.OwnerReference{*podRef}, owner: statefulSetRef, wantUID: statefulSet.UID},
+		{name: "Retained secret", refs: []metav1.OwnerReference{*podRef}},
+		{name: "Secret of another controller", refs: []metav1.OwnerReference{*otherRef}, owner: podRef, wantUID: other.UID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := newTLSSecret("etcd-0-peer", "etcd-namespace")
+			secret.OwnerReferences = tt.refs
+			r := &EtcdCertSigner{client: fake.NewFakeClient(secret)}
+			if err := r.ensureMemberSecretOwner(secret, tt.owner); err != nil {
+				t.Fatalf("ensureMemberSecretOwner() error = %v", err)
+			}
+			got, err := r.getSecret(secret.Name, secret.Namespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var gotUID types.UID
+			if controller := metav1.GetControllerOf(got); controller != nil {
+				gotUID = controller.UID
+			}
+			if gotUID != tt.wantUID || len(got.OwnerReferences) > 1 {
+				t.Errorf("ensureMemberSecretOwner() owners = %v, want controller %q", got.OwnerReferences, tt.wantUID)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package etcdcertsigner
+
+import (
+	"crypto/x509"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// certificatesSigned counts the signed certificates by the CA secret that signed them and the
+	// type of signing: issued for a new key pair, renewed for a replaced one, or csr.
+	certificatesSigned = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "etcd_cert_signer_certificates_signed_total",
+		Help: "Number of certificates signed by CA secret and type of signing.",
+	}, []string{"ca", "type"})
+	// signingFailures counts the failures to sign the certificates of etcd members by the reason
+	// of their warning event.
+	signingFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "etcd_cert_signer_signing_failures_total",
+		Help: "Number of failures to sign the certificates of etcd members by reason.",
+	}, []string{"reason"})
+	// signingDuration observes the time taken to sign a certificate, including the generation of its
+	// key pair unless it was requested by a CSR, which mostly depends on the key algorithm.
+	signingDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "etcd_cert_signer_signing_duration_seconds",
+		Help:    "Time taken to sign a certificate, including the generation of its key pair unless requested by a CSR, by key algorithm.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"key_algorithm"})
+
+	// certificateMetrics exports the expiry of the signed certificates and of the CAs.
+	certificateMetrics = newCertificateCollector()
+)
+
+func init() {
+	metrics.Registry.MustRegister(certificatesSigned, signingFailures, signingDuration, certificateMetrics)
+}
+
+var (
+	certificateExpiryDesc = prometheus.NewDesc("etcd_cert_signer_certificate_expiry_timestamp_seconds",
+		"Time after which a certificate signed for an etcd member, an EtcdCertificate or a CSR expires.",
+		[]string{"namespace", "pod", "secret", "role", "common_name", "issuer"}, nil)
+	certificateRemainingDesc = prometheus.NewDesc("etcd_cert_signer_certificate_remaining_seconds",
+		"Time left until a certificate signed for an etcd member, an EtcdCertificate or a CSR expires.",
+		[]string{"namespace", "pod", "secret", "role", "common_name", "issuer"}, nil)
+	caExpiryDesc = prometheus.NewDesc("etcd_cert_signer_ca_expiry_timestamp_seconds",
+		"Time after which a CA expires.",
+		[]string{"namespace", "secret", "issuer"}, nil)
+	caRemainingDesc = prometheus.NewDesc("etcd_cert_signer_ca_remaining_seconds",
+		"Time left until a CA expires.",
+		[]string{"namespace", "secret", "issuer"}, nil)
+)
+
+// certificateKey identifies an exported certificate: the secret holding it, or the common name of
+// a certificate signed for a CSR, which is not held by a secret the operator knows of.
+type certificateKey struct {
+	namespace  string
+	secret     string
+	commonName string
+}
+
+// certificateExpiry is the expiry of a certificate and the labels it is exported with.
+type certificateExpiry struct {
+	pod        string
+	role       string
+	commonName string
+	issuer     string
+	notAfter   time.Time
+	// etcdCertificate is the EtcdCertificate the certificate was signed for, if any.
+	etcdCertificate string
+}
+
+// certificateCollector is a prometheus.Collector of the expiry of the certificates and CAs seen by
+// the last reconciles. The time left is computed when scraped so that it does not wait for the
+// next reconcile, which may be months away.
+type certificateCollector struct {
+	lock         sync.Mutex
+	certificates map[certificateKey]certificateExpiry
+	cas          map[types.NamespacedName]certificateExpiry
+	now          func() time.Time
+}
+
+func newCertificateCollector() *certificateCollector {
+	return &certificateCollector{
+		certificates: map[certificateKey]certificateExpiry{},
+		cas:          map[types.NamespacedName]certificateExpiry{},
+		now:          time.Now,
+	}
+}
+
+func newCertificateExpiry(role string, cert *x509.Certificate) certificateExpiry {
+	return certificateExpiry{
+		role:       role,
+		commonName: cert.Subject.CommonName,
+		issuer:     cert.Issuer.CommonName,
+		notAfter:   cert.NotAfter,
+	}
+}
+
+// setCertificate records cert as the certificate held by the member secret called name in
+// namespace, signed for role of pod.
+func (c *certificateCollector) setCertificate(namespace string, name string, pod string, role string, cert *x509.Certificate) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	expiry := newCertificateExpiry(role, cert)
+	expiry.pod = pod
+	c.certificates[certificateKey{namespace: namespace, secret: name}] = expiry
+}
+
+// setEtcdCertificate records cert as the certificate held by the secret called name in namespace,
+// signed with profile for the EtcdCertificate called instance. It replaces the certificate of the
+// previous secret of instance.
+func (c *certificateCollector) setEtcdCertificate(namespace string, instance string, name string, profile string, cert *x509.Certificate) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.forgetEtcdCertificateLocked(namespace, instance)
+	expiry := newCertificateExpiry(profile, cert)
+	expiry.etcdCertificate = instance
+	c.certificates[certificateKey{namespace: namespace, secret: name}] = expiry
+}
+
+// setCSRCertificate records cert as signed with profile for a CSR. It replaces the certificate
+// previously signed for the same common name, i.e. for the same member and profile.
+func (c *certificateCollector) setCSRCertificate(profile string, cert *x509.Certificate) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.certificates[certificateKey{commonName: cert.Subject.CommonName}] = newCertificateExpiry(profile, cert)
+}
+
+// setCA records cert as the CA held by the secret called name in namespace.
+func (c *certificateCollector) setCA(namespace string, name string, cert *x509.Certificate) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.cas[types.NamespacedName{Namespace: namespace, Name: name}] = certificateExpiry{
+		issuer:   cert.Issuer.CommonName,
+		notAfter: cert.NotAfter,
+	}
+}
+
+// forgetPod stops exporting the certificates of the member secrets of the pod called name in namespace.
+func (c *certificateCollector) forgetPod(namespace string, name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for key, expiry := range c.certificates {
+		if key.namespace == namespace && expiry.pod == name {
+			delete(c.certificates, key)
+		}
+	}
+}
+
+// forgetEtcdCertificate stops exporting the certificate of the EtcdCertificate called name in namespace.
+func (c *certificateCollector) forgetEtcdCertificate(namespace string, name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.forgetEtcdCertificateLocked(namespace, name)
+}
+
+func (c *certificateCollector) forgetEtcdCertificateLocked(namespace string, name string) {
+	for key, expiry := range c.certificates {
+		if key.namespace == namespace && expiry.etcdCertificate == name {
+			delete(c.certificates, key)
+		}
+	}
+}
+
+// forgetCA stops exporting the CA held by the secret called name in namespace.
+func (c *certificateCollector) forgetCA(namespace string, name string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.cas, types.NamespacedName{Namespace: namespace, Name: name})
+}
+
+// Describe implements prometheus.Collector.
+func (c *certificateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- certificateExpiryDesc
+	ch <- certificateRemainingDesc
+	ch <- caExpiryDesc
+	ch <- caRemainingDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *certificateCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.now()
+	for key, expiry := range c.certificates {
+		if key.secret == "" && expiry.notAfter.Before(now) {
+			// Nothing tells when the requester of a CSR is gone, its certificate is only
+			// exported until it expires
+			delete(c.certificates, key)
+			continue
+		}
+		labels := []string{key.namespace, expiry.pod, key.secret, expiry.role, expiry.commonName, expiry.issuer}
+		ch <- prometheus.MustNewConstMetric(certificateExpiryDesc, prometheus.GaugeValue, float64(expiry.notAfter.Unix()), labels...)
+		ch <- prometheus.MustNewConstMetric(certificateRemainingDesc, prometheus.GaugeValue, expiry.notAfter.Sub(now).Seconds(), labels...)
+	}
+	for key, expiry := range c.cas {
+		labels := []string{key.Namespace, key.Name, expiry.issuer}
+		ch <- prometheus.MustNewConstMetric(caExpiryDesc, prometheus.GaugeValue, float64(expiry.notAfter.Unix()), labels...)
+		ch <- prometheus.MustNewConstMetric(caRemainingDesc, prometheus.GaugeValue, expiry.notAfter.Sub(now).Seconds(), labels...)
+	}
+}
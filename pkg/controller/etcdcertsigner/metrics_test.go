@@ -0,0 +1,78 @@
+package etcdcertsigner
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func Test_certificateCollector(t *testing.T) {
+	now := time.Now()
+	newCert := func(issuer string, notAfter time.Time) *x509.Certificate {
+		return &x509.Certificate{Issuer: pkix.Name{CommonName: issuer}, NotAfter: notAfter}
+	}
+	collected := func(c *certificateCollector) int {
+		ch := make(chan prometheus.Metric, 100)
+		c.Collect(ch)
+		close(ch)
+		return len(ch)
+	}
+
+	c := newCertificateCollector()
+	c.now = func() time.Time { return now }
+	c.setCA(etcdCASecretNamespace, etcdCASecretName, newCert("etcd-signer", now.Add(EtcdCAValidity)))
+	c.setCertificate("etcd-namespace", "etcd-0-peer", "etcd-0", "peer", newCert("etcd-signer", now.Add(time.Hour)))
+	c.setCertificate("etcd-namespace", "etcd-0-server", "etcd-0", "server", newCert("etcd-signer", now.Add(time.Hour)))
+	c.setCertificate("etcd-namespace", "etcd-1-peer", "etcd-1", "peer", newCert("etcd-signer", now.Add(time.Hour)))
+	// A renewal replaces the expiry of the secret
+	c.setCertificate("etcd-namespace", "etcd-1-peer", "etcd-1", "peer", newCert("etcd-signer", now.Add(2*time.Hour)))
+
+	// The expiry and remaining time of each certificate and CA
+	if got := collected(c); got != 8 {
+		t.Errorf("Collect() collected %d metrics, want 8", got)
+	}
+	if got := c.certificates[certificateKey{namespace: "etcd-namespace", secret: "etcd-1-peer"}].notAfter; !got.Equal(now.Add(2 * time.Hour)) {
+		t.Errorf("setCertificate() expiry = %v, want the renewed certificate's", got)
+	}
+
+	c.forgetPod("etcd-namespace", "etcd-0")
+	if got := collected(c); got != 4 {
+		t.Errorf("Collect() collected %d metrics after forgetPod(), want 4", got)
+	}
+
+	// The certificate of an EtcdCertificate moves with its secret
+	c.setEtcdCertificate("etcd-namespace", "apiserver", "apiserver-etcd-client", ClientProfile, newCert("etcd-signer", now.Add(time.Hour)))
+	c.setEtcdCertificate("etcd-namespace", "apiserver", "apiserver-etcd-client-2", ClientProfile, newCert("etcd-signer", now.Add(time.Hour)))
+	if got := collected(c); got != 6 {
+		t.Errorf("Collect() collected %d metrics after setEtcdCertificate(), want 6", got)
+	}
+	c.forgetEtcdCertificate("etcd-namespace", "apiserver")
+	if got := collected(c); got != 4 {
+		t.Errorf("Collect() collected %d metrics after forgetEtcdCertificate(), want 4", got)
+	}
+
+	// A CSR for the same member replaces the previous certificate, which is dropped once expired
+	csrCert := func(notAfter time.Time) *x509.Certificate {
+		cert := newCert("etcd-signer", notAfter)
+		cert.Subject.CommonName = "system:etcd-peer:etcd-2"
+		return cert
+	}
+	c.setCSRCertificate(PeerProfile, csrCert(now.Add(-time.Hour)))
+	c.setCSRCertificate(PeerProfile, csrCert(now.Add(time.Hour)))
+	if got := collected(c); got != 6 {
+		t.Errorf("Collect() collected %d metrics after setCSRCertificate(), want 6", got)
+	}
+	c.now = func() time.Time { return now.Add(2 * time.Hour) }
+	if got := collected(c); got != 4 {
+		t.Errorf("Collect() collected %d metrics after the CSR certificate expired, want 4", got)
+	}
+
+	c.setCA(etcdCASecretNamespace, getNextCASecretName(etcdCASecretName), newCert("etcd-signer", now.Add(EtcdCAValidity)))
+	c.forgetCA(etcdCASecretNamespace, getNextCASecretName(etcdCASecretName))
+	if got := collected(c); got != 4 {
+		t.Errorf("Collect() collected %d metrics after forgetCA(), want 4", got)
+	}
+}
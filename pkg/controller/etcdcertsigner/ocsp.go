@@ -0,0 +1,343 @@
+package etcdcertsigner
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	// EtcdOCSPSignerValidity is the lifetime of the delegated OCSP signing certificate.
+	EtcdOCSPSignerValidity = 30 * 24 * time.Hour
+	// ocspResponseValidity is how long clients may cache an OCSP response.
+	ocspResponseValidity = time.Hour
+	// ocspMaxRequestSize bounds the size of the OCSP requests read from POST bodies.
+	ocspMaxRequestSize = 10 * 1024
+)
+
+// oidOCSPNoCheck marks the delegated OCSP signing certificate as not to be checked for revocation (RFC 6960, section 4.2.2.2.1).
+var oidOCSPNoCheck = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+
+// getOCSPSignerSecretName returns the secret holding the delegated OCSP signer of the CA held by caSecretName.
+func getOCSPSignerSecretName(caSecretName string) string {
+	return caSecretName + "-ocsp"
+}
+
+// blank assignment to verify that OCSPResponder implements manager.Runnable
+var _ manager.Runnable = &OCSPResponder{}
+
+// OCSPResponder answers RFC 6960 OCSP requests over HTTP for the certificates issued by the CA of
+// the peer and server profiles, from the records of the issued and revoked certificates. Responses
+// are signed by a delegated OCSP signing certificate issued from the CA, which is provisioned when
+// the responder starts and kept in memory until it is due for renewal or the CA changes.
+type OCSPResponder struct {
+	// signer reads the CA secret and its records where the operator settings locate them
+	signer *EtcdCertSigner
+	addr   string
+
+	lock sync.Mutex
+	// ocspSigner is the delegated OCSP signer last read or issued
+	ocspSigner *signingCA
+}
+
+// NewOCSPResponder returns an OCSP responder listening on addr, to be added to mgr.
+func NewOCSPResponder(mgr manager.Manager, addr string) *OCSPResponder {
+	return &OCSPResponder{
+		signer: &EtcdCertSigner{client: mgr.GetClient(), scheme: mgr.GetScheme()},
+		addr:   addr,
+	}
+}
+
+// Start serves OCSP requests until stop is closed.
+func (o *OCSPResponder) Start(stop <-chan struct{}) error {
+	// Requests are still answered when the signer cannot be provisioned yet, e.g. before the CA
+	// is bootstrapped: they retry to provision it.
+	if caSecret, ca, err := o.loadCA(); err != nil {
+		log.Error(err, "Unable to load CA of the OCSP responder")
+	} else if _, err := o.getOCSPSigner(caSecret, ca); err != nil {
+		log.Error(err, "Unable to provision OCSP signing certificate", "Secret.Namespace", caSecret.Namespace, "Secret.Name", getOCSPSignerSecretName(caSecret.Name))
+	}
+
+	server := &http.Server{Addr: o.addr, Handler: o}
+	errCh := make(chan error, 1)
+	go func() {
+		log.Info("Starting OCSP responder", "Address", o.addr)
+		errCh <- server.ListenAndServe()
+	}()
+
+	select {
+	case <-stop:
+		return server.Shutdown(context.Background())
+	case err := <-errCh:
+		return err
+	}
+}
+
+// ServeHTTP answers OCSP requests sent with GET or POST (RFC 6960, appendix A.1).
+func (o *OCSPResponder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var der []byte
+	var err error
+	switch req.Method {
+	case http.MethodGet:
+		var path string
+		path, err = url.PathUnescape(strings.TrimPrefix(req.URL.Path, "/"))
+		if err == nil {
+			der, err = base64.StdEncoding.DecodeString(path)
+		}
+	case http.MethodPost:
+		der, err = ioutil.ReadAll(http.MaxBytesReader(w, req.Body, ocspMaxRequestSize))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	response := ocsp.MalformedRequestErrorResponse
+	if err == nil {
+		var ocspReq *ocsp.Request
+		if ocspReq, err = ocsp.ParseRequest(der); err == nil {
+			response, err = o.respond(ocspReq)
+			if err != nil {
+				log.Error(err, "Unable to answer OCSP request", "SerialNumber", ocspReq.SerialNumber.Text(16))
+				response = ocsp.InternalErrorErrorResponse
+				if errors.IsBadRequest(err) {
+					response = ocsp.UnauthorizedErrorResponse
+				}
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Write(response)
+}
+
+// respond returns the signed OCSP response for ocspReq. A BadRequest error is returned when the
+// request is about a certificate of another CA.
+func (o *OCSPResponder) respond(ocspReq *ocsp.Request) ([]byte, error) {
+	caSecret, ca, err := o.loadCA()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkOCSPIssuer(ocspReq, ca.cert); err != nil {
+		return nil, err
+	}
+	responder, err := o.getOCSPSigner(caSecret, ca)
+	if err != nil {
+		return nil, err
+	}
+
+	serial := ocspReq.SerialNumber.Text(16)
+	now := time.Now()
+	template := ocsp.Response{
+		Status:       ocsp.Unknown,
+		SerialNumber: ocspReq.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(ocspResponseValidity),
+		Certificate:  responder.cert,
+	}
+	revoked, err := o.signer.getCertificateRecords(getRevokedCertificatesName(caSecret.Name), caSecret.Namespace, revokedCertificatesKey)
+	if err != nil {
+		return nil, err
+	}
+	issued, err := o.signer.getCertificateRecords(getIssuedCertificatesName(caSecret.Name), caSecret.Namespace, issuedCertificatesKey)
+	if err != nil {
+		return nil, err
+	}
+	if record, ok := findCertificateRecord(revoked, serial); ok {
+		template.Status = ocsp.Revoked
+		template.RevocationReason = ocsp.Unspecified
+		template.RevokedAt = record.NotAfter.Time
+		if record.RevocationTime != nil {
+			template.RevokedAt = record.RevocationTime.Time
+		}
+	} else if _, ok := findCertificateRecord(issued, serial); ok {
+		template.Status = ocsp.Good
+	}
+	return ocsp.CreateResponse(ca.cert, responder.cert, template, responder.key)
+}
+
+// loadCA returns the secret holding the CA the responder answers for and its key pair.
+func (o *OCSPResponder) loadCA() (*corev1.Secret, *signingCA, error) {
+	settings, err := o.signer.getOperatorSettings()
+	if err != nil {
+		return nil, nil, err
+	}
+	caSecret, err := o.signer.getSecret(settings.CASecretName, settings.CASecretNamespace)
+	if err != nil {
+		return nil, nil, err
+	}
+	ca, err := loadSigningCASecret(caSecret)
+	if err != nil {
+		return nil, nil, err
+	}
+	return caSecret, ca, nil
+}
+
+// checkOCSPIssuer returns a BadRequest error unless ocspReq identifies caCert as the issuer.
+func checkOCSPIssuer(ocspReq *ocsp.Request, caCert *x509.Certificate) error {
+	if !ocspReq.HashAlgorithm.Available() {
+		return errors.NewBadRequest("Unsupported OCSP hash algorithm")
+	}
+	subjectPublicKey, err := subjectPublicKeyBytes(caCert.PublicKey)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(hashBytes(ocspReq.HashAlgorithm, caCert.RawSubject), ocspReq.IssuerNameHash) ||
+		!bytes.Equal(hashBytes(ocspReq.HashAlgorithm, subjectPublicKey), ocspReq.IssuerKeyHash) {
+		return errors.NewBadRequest("OCSP request for a certificate of another CA")
+	}
+	return nil
+}
+
+func hashBytes(hash crypto.Hash, data []byte) []byte {
+	h := hash.New()
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func findCertificateRecord(records []certificateRecord, serial string) (*certificateRecord, bool) {
+	for i := range records {
+		if records[i].SerialNumber == serial {
+			return &records[i], true
+		}
+	}
+	return nil, false
+}
+
+// getOCSPSigner returns the delegated OCSP signer of ca, held by caSecret, from memory while it is
+// current, so that answering a request does not read or write its secret.
+func (o *OCSPResponder) getOCSPSigner(caSecret *corev1.Secret, ca *signingCA) (*signingCA, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if o.ocspSigner != nil && isCurrentOCSPSigner(o.ocspSigner.cert, ca) {
+		return o.ocspSigner, nil
+	}
+	responder, err := o.ensureOCSPSigner(caSecret, ca)
+	if err != nil {
+		return nil, err
+	}
+	o.ocspSigner = responder
+	return responder, nil
+}
+
+// isCurrentOCSPSigner returns whether cert is a delegated OCSP signing certificate of ca that is
+// not due for renewal.
+func isCurrentOCSPSigner(cert *x509.Certificate, ca *signingCA) bool {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	renewal := cert.NotBefore.Add(time.Duration(float64(lifetime) * EtcdCertRenewalRatio))
+	return time.Now().Before(renewal) && cert.CheckSignatureFrom(ca.cert) == nil
+}
+
+// loadOCSPSigner returns the delegated OCSP signer of ca held by secret, or nil when it is not current.
+func loadOCSPSigner(secret *corev1.Secret, ca *signingCA) *signingCA {
+	responder, err := loadSigningCA(secret.Data["tls.crt"], secret.Data["tls.key"])
+	if err != nil || !isCurrentOCSPSigner(responder.cert, ca) {
+		return nil
+	}
+	return responder
+}
+
+// ensureOCSPSigner returns the delegated OCSP signer of ca, held by caSecret, issuing a new one
+// when it is missing, due for renewal or was not signed by ca. When another replica of the
+// responder wrote the secret concurrently, its signer is read back instead.
+func (o *OCSPResponder) ensureOCSPSigner(caSecret *corev1.Secret, ca *signingCA) (*signingCA, error) {
+	name := getOCSPSignerSecretName(caSecret.Name)
+	namespace := caSecret.Namespace
+	secret, err := o.signer.getSecret(name, namespace)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	if secret != nil {
+		if responder := loadOCSPSigner(secret, ca); responder != nil {
+			return responder, nil
+		}
+	}
+
+	log.Info("Issuing OCSP signing certificate", "Secret.Namespace", namespace, "Secret.Name", name)
+	key, err := generateKey(KeyAlgorithmECDSAP256)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := ca.signCertificate(&x509.Certificate{
+		Subject: pkix.Name{
+			OrganizationalUnit: ca.cert.Subject.OrganizationalUnit,
+			CommonName:         ca.cert.Subject.CommonName + " OCSP responder",
+		},
+		NotBefore:             time.Now().Add(-1 * time.Second),
+		NotAfter:              time.Now().Add(EtcdOCSPSignerValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+		BasicConstraintsValid: true,
+		ExtraExtensions: []pkix.Extension{
+			{Id: oidOCSPNoCheck, Value: asn1.NullBytes},
+		},
+	}, key.Public())
+	if err != nil {
+		return nil, err
+	}
+	keyBytes, err := encodePrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string][]byte{
+		"tls.crt": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+		"tls.key": keyBytes.Bytes(),
+	}
+	annotations := map[string]string{
+		CertificateNotBeforeAnnotation: cert.NotBefore.Format(time.RFC3339),
+		CertificateNotAfterAnnotation:  cert.NotAfter.Format(time.RFC3339),
+		CertificateIssuer:              cert.Issuer.CommonName,
+	}
+	if secret == nil {
+		err = o.signer.client.Create(context.TODO(), &corev1.Secret{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: corev1.SchemeGroupVersion.String(),
+				Kind:       "Secret",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   namespace,
+				Annotations: annotations,
+			},
+			Data: data,
+			Type: corev1.SecretTypeTLS,
+		})
+	} else {
+		secret.Data = data
+		if secret.Annotations == nil {
+			secret.Annotations = make(map[string]string)
+		}
+		for k, v := range annotations {
+			secret.Annotations[k] = v
+		}
+		err = o.signer.client.Update(context.TODO(), secret)
+	}
+	if errors.IsAlreadyExists(err) || errors.IsConflict(err) {
+		if current, getErr := o.signer.getSecret(name, namespace); getErr == nil {
+			if responder := loadOCSPSigner(current, ca); responder != nil {
+				return responder, nil
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &signingCA{cert: cert, key: key}, nil
+}
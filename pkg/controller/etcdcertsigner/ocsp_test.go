@@ -0,0 +1,171 @@
+package etcdcertsigner
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestOCSPResponder_ServeHTTP(t *testing.T) {
+	const namespace = etcdCASecretNamespace
+	caSecret := newTestCASecret(t, etcdCASecretName, namespace)
+	ca, err := loadSigningCA(caSecret.Data["tls.crt"], caSecret.Data["tls.key"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := &OCSPResponder{signer: &EtcdCertSigner{client: fake.NewFakeClient(caSecret)}}
+
+	issue := func() *x509.Certificate {
+		leafPEM, _, err := ca.makeServerCert([]string{"etcd-0"}, time.Hour, KeyAlgorithmECDSAP256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parseTestCert(t, leafPEM.Bytes())
+	}
+	good := issue()
+	if err := o.signer.recordIssued(etcdCASecretName, namespace, good); err != nil {
+		t.Fatal(err)
+	}
+	revoked := issue()
+	if err := o.signer.recordIssued(etcdCASecretName, namespace, revoked); err != nil {
+		t.Fatal(err)
+	}
+	now := metav1.Now()
+	if err := o.signer.addCertificateRecord(getRevokedCertificatesName(etcdCASecretName), namespace, revokedCertificatesKey, certificateRecord{
+		SerialNumber:   revoked.SerialNumber.Text(16),
+		NotAfter:       metav1.NewTime(revoked.NotAfter),
+		RevocationTime: &now,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	unknown := issue()
+
+	tests := []struct {
+		name       string
+		cert       *x509.Certificate
+		wantStatus int
+	}{
+		{name: "Issued certificate", cert: good, wantStatus: ocsp.Good},
+		{name: "Revoked certificate", cert: revoked, wantStatus: ocsp.Revoked},
+		{name: "Unknown certificate", cert: unknown, wantStatus: ocsp.Unknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			der, err := ocsp.CreateRequest(tt.cert, ca.cert, &ocsp.RequestOptions{Hash: crypto.SHA256})
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := httptest.NewRecorder()
+			o.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(der)))
+
+			resp, err := ocsp.ParseResponseForCert(rec.Body.Bytes(), tt.cert, ca.cert)
+			if err != nil {
+				t.Fatalf("ServeHTTP() returned an invalid response: %v", err)
+			}
+			if resp.Status != tt.wantStatus {
+				t.Errorf("ServeHTTP() status = %v, want %v", resp.Status, tt.wantStatus)
+			}
+			if resp.Certificate == nil || !hasExtKeyUsage(resp.Certificate, x509.ExtKeyUsageOCSPSigning) {
+				t.Errorf("ServeHTTP() response not signed by a delegated OCSP signer")
+			}
+		})
+	}
+
+	t.Run("OCSP signer kept in memory", func(t *testing.T) {
+		secret, err := o.signer.getSecret(getOCSPSignerSecretName(etcdCASecretName), namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := o.signer.client.Delete(context.TODO(), secret); err != nil {
+			t.Fatal(err)
+		}
+		der, err := ocsp.CreateRequest(good, ca.cert, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		o.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(der)))
+		if resp, err := ocsp.ParseResponseForCert(rec.Body.Bytes(), good, ca.cert); err != nil || resp.Status != ocsp.Good {
+			t.Errorf("ServeHTTP() = %v, %v, want a good response", resp, err)
+		}
+		if _, err := o.signer.getSecret(getOCSPSignerSecretName(etcdCASecretName), namespace); !errors.IsNotFound(err) {
+			t.Errorf("ServeHTTP() wrote the OCSP signer secret again, error = %v", err)
+		}
+	})
+
+	t.Run("Certificate of another CA", func(t *testing.T) {
+		other := newTestCA(t, true)
+		leafPEM, _, err := other.makeServerCert([]string{"etcd-0"}, time.Hour, KeyAlgorithmECDSAP256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := ocsp.CreateRequest(parseTestCert(t, leafPEM.Bytes()), other.cert, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		o.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(der)))
+		if !bytes.Equal(rec.Body.Bytes(), ocsp.UnauthorizedErrorResponse) {
+			t.Errorf("ServeHTTP() = %x, want an unauthorized response", rec.Body.Bytes())
+		}
+	})
+}
+
+// staleClient answers the first Get of a secret called name with NotFound, as a cache that did not
+// see the secret written by another replica yet.
+type staleClient struct {
+	client.Client
+	name  string
+	stale bool
+}
+
+func (c *staleClient) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	if key.Name == c.name && !c.stale {
+		c.stale = true
+		return errors.NewNotFound(schema.GroupResource{Resource: "secrets"}, key.Name)
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+func TestOCSPResponder_ensureOCSPSigner_concurrent(t *testing.T) {
+	ca := newTestCA(t, true)
+	caSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: etcdCASecretName, Namespace: etcdCASecretNamespace}}
+	c := fake.NewFakeClient()
+	other := &OCSPResponder{signer: &EtcdCertSigner{client: c}}
+	want, err := other.ensureOCSPSigner(caSecret, ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := &OCSPResponder{signer: &EtcdCertSigner{client: &staleClient{Client: c, name: getOCSPSignerSecretName(etcdCASecretName)}}}
+	got, err := o.ensureOCSPSigner(caSecret, ca)
+	if err != nil {
+		t.Fatalf("ensureOCSPSigner() error = %v, want the signer written by the other replica", err)
+	}
+	if got.cert.SerialNumber.Cmp(want.cert.SerialNumber) != 0 {
+		t.Errorf("ensureOCSPSigner() serial = %v, want %v", got.cert.SerialNumber, want.cert.SerialNumber)
+	}
+}
+
+func hasExtKeyUsage(cert *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	for _, u := range cert.ExtKeyUsage {
+		if u == usage {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,220 @@
+package etcdcertsigner
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// PeerProfile signs certificates used for etcd peer communication.
+	PeerProfile = "peer"
+	// ServerProfile signs certificates served on the etcd client port.
+	ServerProfile = "server"
+	// MetricsProfile signs certificates served on the etcd metrics port.
+	MetricsProfile = "metrics"
+	// ClientProfile signs certificates for consumers of the etcd client port.
+	ClientProfile = "client"
+)
+
+// signingProfile describes how the certificate for a secret is signed.
+type signingProfile struct {
+	// Validity is the lifetime of the issued certificates.
+	Validity metav1.Duration `json:"validity,omitempty"`
+	// KeyUsages lists the key usages of the issued certificates, e.g. "digital signature". When
+	// empty, they are derived from the key of each certificate, see defaultKeyUsage.
+	KeyUsages []string `json:"keyUsages,omitempty"`
+	// ExtKeyUsages lists the extended key usages of the issued certificates, e.g. "server auth".
+	ExtKeyUsages []string `json:"extKeyUsages,omitempty"`
+	// Subject is the template for the subject of the issued certificates.
+	Subject subjectTemplate `json:"subject,omitempty"`
+	// CASecretName is the name of the secret holding the CA that signs the certificates.
+	CASecretName string `json:"caSecretName,omitempty"`
+	// KeyAlgorithm is the algorithm of the generated private keys, e.g. "ecdsa-p256".
+	KeyAlgorithm string `json:"keyAlgorithm,omitempty"`
+
+	// ocspServers are the OCSP responders advertised by the issued certificates, from the settings.
+	ocspServers []string
+}
+
+// subjectTemplate describes the subject of an issued certificate.
+type subjectTemplate struct {
+	Organization       []string `json:"organization,omitempty"`
+	OrganizationalUnit []string `json:"organizationalUnit,omitempty"`
+	// CommonName is a text/template rendered with subjectTemplateData.
+	CommonName string `json:"commonName,omitempty"`
+}
+
+// subjectTemplateData is the data available to the common name template of a profile.
+type subjectTemplateData struct {
+	// Identity is the etcd identity of the secret.
+	Identity string
+	// SecretName is the name of the secret the certificate is issued for.
+	SecretName string
+	// Hostname is the first hostname of the certificate.
+	Hostname string
+}
+
+var keyUsages = map[string]x509.KeyUsage{
+	"digital signature":  x509.KeyUsageDigitalSignature,
+	"content commitment": x509.KeyUsageContentCommitment,
+	"key encipherment":   x509.KeyUsageKeyEncipherment,
+	"key agreement":      x509.KeyUsageKeyAgreement,
+	"data encipherment":  x509.KeyUsageDataEncipherment,
+	"cert sign":          x509.KeyUsageCertSign,
+	"crl sign":           x509.KeyUsageCRLSign,
+	"encipher only":      x509.KeyUsageEncipherOnly,
+	"decipher only":      x509.KeyUsageDecipherOnly,
+}
+
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	"any":          x509.ExtKeyUsageAny,
+	"server auth":  x509.ExtKeyUsageServerAuth,
+	"client auth":  x509.ExtKeyUsageClientAuth,
+	"code signing": x509.ExtKeyUsageCodeSigning,
+	"ocsp signing": x509.ExtKeyUsageOCSPSigning,
+}
+
+// defaultProfiles returns the profiles used when the operator config does not define them.
+func defaultProfiles(settings *operatorSettings) map[string]signingProfile {
+	profile := func(org string, caSecretName string, extKeyUsages ...string) signingProfile {
+		return signingProfile{
+			Validity:     *settings.CertificateValidity,
+			ExtKeyUsages: extKeyUsages,
+			Subject: subjectTemplate{
+				Organization: []string{org},
+				CommonName:   "{{.Identity}}",
+			},
+			CASecretName: caSecretName,
+			KeyAlgorithm: KeyAlgorithmRSA2048,
+			ocspServers:  settings.getOCSPServers(caSecretName),
+		}
+	}
+	return map[string]signingProfile{
+		PeerProfile:    profile("system:peers", settings.CASecretName, "client auth", "server auth"),
+		ServerProfile:  profile("system:servers", settings.CASecretName, "client auth", "server auth"),
+		MetricsProfile: profile("system:metrics", settings.MetricsCASecretName, "client auth", "server auth"),
+		ClientProfile:  profile("system:clients", settings.CASecretName, "client auth"),
+	}
+}
+
+// setDefaults fills the fields left empty in a configured profile from the defaults and settings.
+func (p *signingProfile) setDefaults(settings *operatorSettings) {
+	if p.Validity.Duration == 0 {
+		p.Validity = *settings.CertificateValidity
+	}
+	if len(p.ExtKeyUsages) == 0 {
+		p.ExtKeyUsages = []string{"client auth", "server auth"}
+	}
+	if p.Subject.CommonName == "" {
+		p.Subject.CommonName = "{{.Identity}}"
+	}
+	if p.CASecretName == "" {
+		p.CASecretName = settings.CASecretName
+	}
+	if p.KeyAlgorithm == "" {
+		p.KeyAlgorithm = KeyAlgorithmRSA2048
+	}
+	p.ocspServers = settings.getOCSPServers(p.CASecretName)
+}
+
+// validate checks that the key algorithm, the usages and the common name template of the profile can be used.
+func (p *signingProfile) validate() error {
+	if p.Validity.Duration < 0 {
+		return errors.NewBadRequest("Profile validity must be positive")
+	}
+	if err := validateKeyAlgorithm(p.KeyAlgorithm); err != nil {
+		return err
+	}
+	if _, err := p.keyUsage(nil); err != nil {
+		return err
+	}
+	if _, err := p.extKeyUsage(); err != nil {
+		return err
+	}
+	_, err := template.New("commonName").Parse(p.Subject.CommonName)
+	return err
+}
+
+// keyUsage returns the key usage of the certificates of the profile for publicKey.
+func (p *signingProfile) keyUsage(publicKey crypto.PublicKey) (x509.KeyUsage, error) {
+	if len(p.KeyUsages) == 0 {
+		return defaultKeyUsage(publicKey), nil
+	}
+	var usage x509.KeyUsage
+	for _, name := range p.KeyUsages {
+		u, ok := keyUsages[name]
+		if !ok {
+			return 0, errors.NewBadRequest("Unknown key usage " + name)
+		}
+		usage |= u
+	}
+	return usage, nil
+}
+
+func (p *signingProfile) extKeyUsage() ([]x509.ExtKeyUsage, error) {
+	var usages []x509.ExtKeyUsage
+	for _, name := range p.ExtKeyUsages {
+		u, ok := extKeyUsages[name]
+		if !ok {
+			return nil, errors.NewBadRequest("Unknown extended key usage " + name)
+		}
+		usages = append(usages, u)
+	}
+	return usages, nil
+}
+
+// apply sets the subject and extensions of the profile on a certificate template.
+func (p *signingProfile) apply(cert *x509.Certificate, data subjectTemplateData) error {
+	tmpl, err := template.New("commonName").Option("missingkey=error").Parse(p.Subject.CommonName)
+	if err != nil {
+		return err
+	}
+	cn := &bytes.Buffer{}
+	if err := tmpl.Execute(cn, data); err != nil {
+		return err
+	}
+	cert.Subject = pkix.Name{
+		Organization:       p.Subject.Organization,
+		OrganizationalUnit: p.Subject.OrganizationalUnit,
+		CommonName:         cn.String(),
+	}
+	return p.applyExtensions(cert)
+}
+
+// applyExtensions sets the usages and the OCSP responders of the profile on a certificate template,
+// whose PublicKey is the key the certificate is signed for.
+func (p *signingProfile) applyExtensions(cert *x509.Certificate) error {
+	var err error
+	if cert.KeyUsage, err = p.keyUsage(cert.PublicKey); err != nil {
+		return err
+	}
+	if cert.ExtKeyUsage, err = p.extKeyUsage(); err != nil {
+		return err
+	}
+	cert.OCSPServer = p.ocspServers
+	return nil
+}
+
+// getKeyAlgorithm returns the key algorithm annotated on secret, or the one of the profile when
+// there is none.
+func (p *signingProfile) getKeyAlgorithm(secret *corev1.Secret) string {
+	if keyAlgorithm, ok := secret.GetAnnotations()[CertificateKeyAlgorithm]; ok && keyAlgorithm != "" {
+		return keyAlgorithm
+	}
+	return p.KeyAlgorithm
+}
+
+// getProfileName returns the profile annotated on secret, or defaultProfile when there is none.
+func getProfileName(secret *corev1.Secret, defaultProfile string) string {
+	if name, ok := secret.GetAnnotations()[CertificateProfile]; ok && name != "" {
+		return name
+	}
+	return defaultProfile
+}
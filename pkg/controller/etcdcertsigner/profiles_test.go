@@ -0,0 +1,186 @@
+package etcdcertsigner
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"reflect"
+	"testing"
+
+	etcdv1alpha1 "github.com/alaypatel07/etcd-cert-signer/pkg/apis/etcd/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_signingProfile_apply(t *testing.T) {
+	data := subjectTemplateData{
+		Identity:   "system:peer:etcd-0",
+		SecretName: "etcd-0-peer",
+		Hostname:   "etcd-0.etcd.test",
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name            string
+		profile         signingProfile
+		publicKey       crypto.PublicKey
+		wantCommonName  string
+		wantKeyUsage    x509.KeyUsage
+		wantExtKeyUsage []x509.ExtKeyUsage
+		wantErr         bool
+	}{
+		{
+			name:            "Default peer profile",
+			profile:         defaultProfiles(defaultOperatorSettings())[PeerProfile],
+			publicKey:       rsaKey.Public(),
+			wantCommonName:  "system:peer:etcd-0",
+			wantKeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			wantExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		},
+		{
+			name:            "Default client profile",
+			profile:         defaultProfiles(defaultOperatorSettings())[ClientProfile],
+			publicKey:       rsaKey.Public(),
+			wantCommonName:  "system:peer:etcd-0",
+			wantKeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			wantExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		},
+		{
+			name:            "Default peer profile with an ECDSA key",
+			profile:         defaultProfiles(defaultOperatorSettings())[PeerProfile],
+			publicKey:       ecdsaKey.Public(),
+			wantCommonName:  "system:peer:etcd-0",
+			wantKeyUsage:    x509.KeyUsageDigitalSignature,
+			wantExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		},
+		{
+			name: "Configured key usages with an ECDSA key",
+			profile: signingProfile{
+				KeyUsages:    []string{"digital signature", "key agreement"},
+				ExtKeyUsages: []string{"server auth"},
+				Subject:      subjectTemplate{CommonName: "{{.Identity}}"},
+			},
+			publicKey:       ecdsaKey.Public(),
+			wantCommonName:  "system:peer:etcd-0",
+			wantKeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyAgreement,
+			wantExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		},
+		{
+			name: "Server only profile with templated common name",
+			profile: signingProfile{
+				KeyUsages:    []string{"digital signature"},
+				ExtKeyUsages: []string{"server auth"},
+				Subject:      subjectTemplate{CommonName: "{{.Hostname}}"},
+			},
+			wantCommonName:  "etcd-0.etcd.test",
+			wantKeyUsage:    x509.KeyUsageDigitalSignature,
+			wantExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		},
+		{
+			name: "Unknown template field",
+			profile: signingProfile{
+				Subject: subjectTemplate{CommonName: "{{.Unknown}}"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Unknown extended key usage",
+			profile: signingProfile{
+				ExtKeyUsages: []string{"teleport"},
+				Subject:      subjectTemplate{CommonName: "{{.Identity}}"},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := &x509.Certificate{PublicKey: tt.publicKey}
+			err := tt.profile.apply(cert, data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("apply() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if cert.Subject.CommonName != tt.wantCommonName {
+				t.Errorf("apply() CommonName = %v, want %v", cert.Subject.CommonName, tt.wantCommonName)
+			}
+			if cert.KeyUsage != tt.wantKeyUsage {
+				t.Errorf("apply() KeyUsage = %v, want %v", cert.KeyUsage, tt.wantKeyUsage)
+			}
+			if !reflect.DeepEqual(cert.ExtKeyUsage, tt.wantExtKeyUsage) {
+				t.Errorf("apply() ExtKeyUsage = %v, want %v", cert.ExtKeyUsage, tt.wantExtKeyUsage)
+			}
+		})
+	}
+}
+
+func Test_signingProfile_apply_ocspServers(t *testing.T) {
+	settings, err := newOperatorSettings(&etcdv1alpha1.EtcdCertSignerConfigSpec{OCSPURL: "http://etcd-cert-signer.openshift-etcd.svc:8889"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	profiles := defaultProfiles(settings)
+	metrics := signingProfile{CASecretName: settings.MetricsCASecretName}
+	metrics.setDefaults(settings)
+
+	tests := []struct {
+		name    string
+		profile signingProfile
+		want    []string
+	}{
+		{name: "Profile of the etcd CA", profile: profiles[PeerProfile], want: []string{settings.OCSPURL}},
+		{name: "Profile of the metrics CA", profile: profiles[MetricsProfile]},
+		{name: "Configured profile of the metrics CA", profile: metrics},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := &x509.Certificate{}
+			if err := tt.profile.apply(cert, subjectTemplateData{Identity: "system:peer:etcd-0"}); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(cert.OCSPServer, tt.want) {
+				t.Errorf("apply() OCSPServer = %v, want %v", cert.OCSPServer, tt.want)
+			}
+		})
+	}
+}
+
+func Test_getProfileName(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        string
+	}{
+		{
+			name: "Profile annotated",
+			annotations: map[string]string{
+				CertificateProfile: ClientProfile,
+			},
+			want: ClientProfile,
+		},
+		{
+			name: "Profile not annotated",
+			want: PeerProfile,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := &corev1.Secret{ObjectMeta: v1.ObjectMeta{Annotations: tt.annotations}}
+			if got := getProfileName(secret, PeerProfile); got != tt.want {
+				t.Errorf("getProfileName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package etcdcertsigner
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// issuedCertificatesKey is the ConfigMap key holding the certificates issued by a CA in YAML.
+	issuedCertificatesKey = "issued.yaml"
+	// maxCertificateRecordsSize bounds the size of the records held by a ConfigMap, below the 1 MiB
+	// limit of objects stored in etcd. It holds about 10000 records of unexpired certificates.
+	maxCertificateRecordsSize = 768 * 1024
+)
+
+// getIssuedCertificatesName returns the ConfigMap recording the certificates issued for the CA held by caSecretName.
+func getIssuedCertificatesName(caSecretName string) string {
+	return caSecretName + "-issued"
+}
+
+// certificateRecord is a certificate recorded as issued or revoked by a CA. It only holds what the
+// OCSP responder and the CRLs need, the secret or CSR a certificate was issued for is in the events.
+type certificateRecord struct {
+	// SerialNumber is the hexadecimal serial number of the certificate.
+	SerialNumber string `json:"serialNumber"`
+	// NotAfter is the expiry of the certificate, after which the record is dropped.
+	NotAfter metav1.Time `json:"notAfter"`
+	// RevocationTime is the time a revoked certificate was revoked.
+	RevocationTime *metav1.Time `json:"revocationTime,omitempty"`
+}
+
+// getCertificateRecords returns the certificates recorded under key in the ConfigMap called name.
+func (r *EtcdCertSigner) getCertificateRecords(name string, namespace string, key string) ([]certificateRecord, error) {
+	cm, err := r.getConfigMap(name, namespace)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return loadCertificateRecords(cm, key)
+}
+
+func loadCertificateRecords(cm *corev1.ConfigMap, key string) ([]certificateRecord, error) {
+	var records []certificateRecord
+	if err := yaml.Unmarshal([]byte(cm.Data[key]), &records); err != nil {
+		return nil, errors.NewBadRequest("Unable to parse certificate records " + cm.Name + ": " + err.Error())
+	}
+	return records, nil
+}
+
+// addCertificateRecord adds record under key in the ConfigMap called name, creating it when it
+// does not exist, and drops the records of expired certificates. A BadRequest error is returned
+// when the records would exceed maxCertificateRecordsSize.
+func (r *EtcdCertSigner) addCertificateRecord(name string, namespace string, key string, record certificateRecord) error {
+	cm, err := r.getConfigMap(name, namespace)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	var records []certificateRecord
+	if cm != nil {
+		if records, err = loadCertificateRecords(cm, key); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	var kept []certificateRecord
+	for _, c := range records {
+		if c.SerialNumber == record.SerialNumber {
+			// Already recorded
+			return nil
+		}
+		if c.NotAfter.Time.After(now) {
+			kept = append(kept, c)
+		}
+	}
+	kept = append(kept, record)
+	data, err := yaml.Marshal(kept)
+	if err != nil {
+		return err
+	}
+	if len(data) > maxCertificateRecordsSize {
+		return errors.NewBadRequest(fmt.Sprintf("Certificate records %s/%s would exceed %d bytes with %d unexpired certificates, no more can be recorded until some expire",
+			namespace, name, maxCertificateRecordsSize, len(kept)))
+	}
+
+	if cm == nil {
+		return r.client.Create(context.TODO(), &corev1.ConfigMap{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: corev1.SchemeGroupVersion.String(),
+				Kind:       "ConfigMap",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+			},
+			Data: map[string]string{
+				key: string(data),
+			},
+		})
+	}
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data[key] = string(data)
+	return r.client.Update(context.TODO(), cm)
+}
+
+// recordIssued records cert as issued for the CA held by caSecretName. It is called before cert is
+// handed out, so that the OCSP responder knows every certificate in use.
+func (r *EtcdCertSigner) recordIssued(caSecretName string, namespace string, cert *x509.Certificate) error {
+	return r.addCertificateRecord(getIssuedCertificatesName(caSecretName), namespace, issuedCertificatesKey, certificateRecord{
+		SerialNumber: cert.SerialNumber.Text(16),
+		NotAfter:     metav1.NewTime(cert.NotAfter),
+	})
+}
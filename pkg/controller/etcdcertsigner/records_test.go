@@ -0,0 +1,89 @@
+package etcdcertsigner
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+)
+
+func TestEtcdCertSigner_addCertificateRecord(t *testing.T) {
+	const name = "etcd-ca-issued"
+	expiry := metav1.NewTime(time.Now().Add(time.Hour).Truncate(time.Second))
+	recordsConfigMap := func(records ...certificateRecord) *corev1.ConfigMap {
+		data, err := yaml.Marshal(records)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: etcdCASecretNamespace},
+			Data:       map[string]string{issuedCertificatesKey: string(data)},
+		}
+	}
+	var full []certificateRecord
+	for i := 0; i < 12000; i++ {
+		full = append(full, certificateRecord{SerialNumber: fmt.Sprintf("%032x", i), NotAfter: expiry})
+	}
+
+	tests := []struct {
+		name           string
+		objects        []runtime.Object
+		want           []string
+		wantBadRequest bool
+	}{
+		{
+			name: "First record",
+			want: []string{"c0ffee"},
+		},
+		{
+			name: "Expired records are dropped",
+			objects: []runtime.Object{recordsConfigMap(
+				certificateRecord{SerialNumber: "1", NotAfter: metav1.NewTime(time.Now().Add(-time.Hour))},
+				certificateRecord{SerialNumber: "2", NotAfter: expiry},
+			)},
+			want: []string{"2", "c0ffee"},
+		},
+		{
+			name:    "Already recorded",
+			objects: []runtime.Object{recordsConfigMap(certificateRecord{SerialNumber: "c0ffee", NotAfter: expiry})},
+			want:    []string{"c0ffee"},
+		},
+		{
+			name:           "Records too large",
+			objects:        []runtime.Object{recordsConfigMap(full...)},
+			wantBadRequest: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &EtcdCertSigner{client: fake.NewFakeClient(tt.objects...)}
+			err := r.addCertificateRecord(name, etcdCASecretNamespace, issuedCertificatesKey, certificateRecord{SerialNumber: "c0ffee", NotAfter: expiry})
+			if tt.wantBadRequest {
+				if !errors.IsBadRequest(err) {
+					t.Errorf("addCertificateRecord() error = %v, want a BadRequest", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("addCertificateRecord() error = %v", err)
+			}
+			records, err := r.getCertificateRecords(name, etcdCASecretNamespace, issuedCertificatesKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, record := range records {
+				got = append(got, record.SerialNumber)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("addCertificateRecord() records = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
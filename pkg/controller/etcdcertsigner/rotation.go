@@ -0,0 +1,397 @@
+package etcdcertsigner
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	etcdv1alpha1 "github.com/alaypatel07/etcd-cert-signer/pkg/apis/etcd/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CARotationAnnotation requests a rotation of the CA held by the annotated CA secret when set to "true".
+// The annotation is removed once the rotation has completed.
+const CARotationAnnotation = "auth.openshift.io/ca-rotation"
+
+// caRotationResyncPeriod is how often the progress of a CA rotation is checked.
+const caRotationResyncPeriod = 30 * time.Second
+
+const (
+	// caRotationPhaseKey holds the current phase of the rotation in the status ConfigMap.
+	caRotationPhaseKey = "phase"
+	// caRotationMessageKey holds a human readable description of what the rotation is waiting for.
+	caRotationMessageKey = "message"
+	// caRotationStartedKey holds the time the rotation was started.
+	caRotationStartedKey = "startedAt"
+	// caRotationTransitionKey holds the time the rotation entered the current phase.
+	caRotationTransitionKey = "lastTransitionTime"
+)
+
+// caRotationPhase is a stage of the CA rotation workflow.
+type caRotationPhase string
+
+const (
+	// CARotationTrustDistributed means the bundle holding the old and the new CA has been
+	// published and the rotation waits for the etcd container of all members to be restarted
+	// with it.
+	CARotationTrustDistributed caRotationPhase = "TrustDistributed"
+	// CARotationResigning means all members trust the new CA and the member and EtcdCertificate
+	// secrets are being re-signed with it.
+	CARotationResigning caRotationPhase = "Resigning"
+	// CARotationCompleted means the new CA replaced the old one, which was dropped from the bundle.
+	CARotationCompleted caRotationPhase = "Completed"
+)
+
+// getCARotationStatusName returns the ConfigMap reporting the rotation of the CA held by caSecretName.
+func getCARotationStatusName(caSecretName string) string {
+	return caSecretName + "-rotation"
+}
+
+// getNextCASecretName returns the secret holding the new CA while the CA held by caSecretName is rotated.
+func getNextCASecretName(caSecretName string) string {
+	return caSecretName + "-next"
+}
+
+// reconcileCARotation advances the rotation of the CA held by caSecret and returns the secret
+// holding the CA that signs certificates in the current phase. The returned bool reports whether
+// a rotation is in progress.
+func (r *EtcdCertSigner) reconcileCARotation(caSecret *corev1.Secret, caConfig *caConfig, config *signerConfig) (*corev1.Secret, bool, error) {
+	status, err := r.getConfigMap(getCARotationStatusName(caSecret.Name), caSecret.Namespace)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, false, err
+	}
+	var phase caRotationPhase
+	if status != nil {
+		phase = caRotationPhase(status.Data[caRotationPhaseKey])
+	}
+
+	if phase == "" || phase == CARotationCompleted {
+		if caSecret.GetAnnotations()[CARotationAnnotation] != "true" {
+			return caSecret, false, nil
+		}
+		return r.startCARotation(caSecret, caConfig, config, status)
+	}
+
+	next, err := r.getSecret(getNextCASecretName(caSecret.Name), caSecret.Namespace)
+	if err != nil {
+		return nil, true, err
+	}
+	// Keep publishing both CAs until the old one is dropped
+	if err := r.ensureRotationBundle(caSecret, next, caConfig, config); err != nil {
+		return nil, true, err
+	}
+
+	switch phase {
+	case CARotationTrustDistributed:
+		return r.waitForCATrust(caSecret, next, config, status)
+	case CARotationResigning:
+		return r.finishCARotation(caSecret, next, caConfig, config, status)
+	}
+	return nil, true, errors.NewBadRequest("Unknown CA rotation phase " + string(phase))
+}
+
+// startCARotation generates the new CA, publishes it next to the old one and waits for the
+// etcd members to trust it.
+func (r *EtcdCertSigner) startCARotation(caSecret *corev1.Secret, caConfig *caConfig, config *signerConfig, status *corev1.ConfigMap) (*corev1.Secret, bool, error) {
+	if len(caSecret.Data[CAChainKey]) > 0 {
+		// The new CA would have to be signed by the root, whose key is not in the cluster
+		return nil, false, errors.NewBadRequest("Rotation of intermediate CA " + caSecret.Name + " is not supported")
+	}
+	log.Info("Starting CA rotation", "Secret.Namespace", caSecret.Namespace, "Secret.Name", caSecret.Name)
+
+	nextName := getNextCASecretName(caSecret.Name)
+	next, err := r.getSecret(nextName, caSecret.Namespace)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return nil, true, err
+		}
+		next, err = r.createCASecret(nextName, caSecret.Namespace, caConfig)
+		if err != nil {
+			return nil, true, err
+		}
+	}
+	if err := r.ensureRotationBundle(caSecret, next, caConfig, config); err != nil {
+		return nil, true, err
+	}
+
+	err = r.updateCARotationStatus(caSecret, status, CARotationTrustDistributed,
+		"Waiting for the etcd members to restart and trust the CA bundle "+caConfig.BundleConfigMapName)
+	return caSecret, true, err
+}
+
+// waitForCATrust switches to signing with the new CA once the etcd container of every member was
+// started after the bundle holding both CAs was published. etcd only reads its trusted CAs on
+// startup, so a restart of the etcd container is needed, either in place or by recreating the
+// pod, while the restarts of the other containers of the pod do not count. The bundle ConfigMaps
+// and the ca.crt keys of the member secrets are updated when this phase starts, the members
+// should be restarted once the kubelet has synced their mounted files.
+func (r *EtcdCertSigner) waitForCATrust(caSecret *corev1.Secret, next *corev1.Secret, config *signerConfig, status *corev1.ConfigMap) (*corev1.Secret, bool, error) {
+	published, err := time.Parse(time.RFC3339, status.Data[caRotationTransitionKey])
+	if err != nil {
+		return nil, true, errors.NewBadRequest("Unable to parse CA rotation transition time: " + err.Error())
+	}
+
+	pods, err := r.getEtcdPods("", config.Settings)
+	if err != nil {
+		return nil, true, err
+	}
+	var pending int
+	for i := range pods {
+		started := getEtcdStartTime(&pods[i], config.Settings.EtcdContainerName)
+		if started == nil || !started.Time.After(published) {
+			pending++
+		}
+	}
+	if pending > 0 {
+		err := r.updateCARotationStatus(caSecret, status, CARotationTrustDistributed,
+			fmt.Sprintf("Waiting for %d of %d etcd members to restart and trust the CA bundle", pending, len(pods)))
+		return caSecret, true, err
+	}
+
+	log.Info("All etcd members trust the new CA, re-signing member secrets", "Secret.Namespace", caSecret.Namespace, "Secret.Name", caSecret.Name)
+	err = r.updateCARotationStatus(caSecret, status, CARotationResigning, "Re-signing the etcd member secrets with the new CA")
+	return next, true, err
+}
+
+// finishCARotation replaces the old CA with the new one and drops it from the bundle once every
+// member and EtcdCertificate secret signed by this CA was re-signed with the new CA.
+func (r *EtcdCertSigner) finishCARotation(caSecret *corev1.Secret, next *corev1.Secret, caConfig *caConfig, config *signerConfig, status *corev1.ConfigMap) (*corev1.Secret, bool, error) {
+	pending, err := r.countSecretsNotSignedBy(caSecret.Name, next, config)
+	if err != nil {
+		return nil, true, err
+	}
+	if pending > 0 {
+		err := r.updateCARotationStatus(caSecret, status, CARotationResigning,
+			fmt.Sprintf("Waiting for %d member and EtcdCertificate secrets to be re-signed with the new CA", pending))
+		return next, true, err
+	}
+
+	log.Info("Completing CA rotation", "Secret.Namespace", caSecret.Namespace, "Secret.Name", caSecret.Name)
+	caSecret.Data = next.Data
+	delete(caSecret.Annotations, CARotationAnnotation)
+	if err := r.client.Update(context.TODO(), caSecret); err != nil {
+		return nil, true, err
+	}
+	caCert, err := parseCertificate(caSecret.Data["tls.crt"])
+	if err != nil {
+		return nil, true, err
+	}
+	if err := r.ensureCABundle(caConfig.BundleConfigMapName, config.Settings, caCert); err != nil {
+		return nil, true, err
+	}
+	if err := r.client.Delete(context.TODO(), next); err != nil && !errors.IsNotFound(err) {
+		return nil, true, err
+	}
+	certificateMetrics.forgetCA(next.Namespace, next.Name)
+	err = r.updateCARotationStatus(caSecret, status, CARotationCompleted, "The CA was rotated and the old CA was removed from the CA bundle")
+	return caSecret, false, err
+}
+
+// countSecretsNotSignedBy returns how many member secrets of the etcd pods and secrets of
+// EtcdCertificates whose profile uses the CA held by caSecretName were not signed by next yet.
+func (r *EtcdCertSigner) countSecretsNotSignedBy(caSecretName string, next *corev1.Secret, config *signerConfig) (int, error) {
+	pods, err := r.getEtcdPods("", config.Settings)
+	if err != nil {
+		return 0, err
+	}
+	var pending int
+	for i := range pods {
+		for _, member := range config.Settings.getMemberSecrets(&pods[i]) {
+			secret, err := r.getSecret(member.name, pods[i].Namespace)
+			if err != nil {
+				if errors.IsNotFound(err) {
+					continue
+				}
+				return 0, err
+			}
+			profile, err := config.getProfile(getProfileName(secret, member.profile))
+			if err != nil {
+				return 0, err
+			}
+			if profile.CASecretName == caSecretName && !isSignedBy(secret, next) {
+				pending++
+			}
+		}
+	}
+
+	instances := &etcdv1alpha1.EtcdCertificateList{}
+	if err := r.client.List(context.TODO(), &client.ListOptions{}, instances); err != nil {
+		return 0, err
+	}
+	// EtcdCertificates are signed with the configuration of their namespace
+	configs := map[string]*signerConfig{}
+	for i := range instances.Items {
+		instance := &instances.Items[i]
+		instanceConfig, ok := configs[instance.Namespace]
+		if !ok {
+			if instanceConfig, err = r.getSignerConfig(instance.Namespace); err != nil {
+				return 0, err
+			}
+			configs[instance.Namespace] = instanceConfig
+		}
+		profile, err := instanceConfig.getProfile(instance.Spec.Profile)
+		if err != nil || profile.CASecretName != caSecretName {
+			// An EtcdCertificate with an unknown profile has no certificate to re-sign
+			continue
+		}
+		secret, err := r.getSecret(instance.Spec.SecretName, instance.Namespace)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return 0, err
+		}
+		// Secrets the EtcdCertificate refuses to overwrite are never re-signed
+		if metav1.IsControlledBy(secret, instance) && !isSignedBy(secret, next) {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+// ensureCertificateWithCA signs the certificate described by req into secret with ca, the CA of
+// the profile of req in the current phase of its rotation, when needed. Outside of a rotation,
+// certificates that no longer chain to ca are kept for the grace period of the CA. While the CA
+// is rotated, they are re-signed as soon as the members trust the new CA, secret trusts the roots
+// of both CAs and the returned renewal time is at most caRotationResyncPeriod away, so that the
+// progress of the rotation is checked.
+func (r *EtcdCertSigner) ensureCertificateWithCA(ca *corev1.Secret, rotating bool, secret *corev1.Secret, req *certificateRequest, config *signerConfig) (time.Time, error) {
+	if rotating {
+		caBundle, err := r.getRotationCARoots(req.profile.CASecretName, config.Settings.CASecretNamespace)
+		if err != nil {
+			return time.Time{}, err
+		}
+		req.gracePeriod, req.caBundle = 0, caBundle
+	} else {
+		caConfig, _ := config.getCAConfig(req.profile.CASecretName)
+		req.gracePeriod = caConfig.GracePeriod.Duration
+	}
+
+	renewal, err := r.ensureCertificate(ca, secret, req)
+	if err != nil || !rotating {
+		return renewal, err
+	}
+	if resync := time.Now().Add(caRotationResyncPeriod); resync.Before(renewal) {
+		renewal = resync
+	}
+	return renewal, nil
+}
+
+// ensureRotationBundle publishes both the old and the new CA certificates.
+func (r *EtcdCertSigner) ensureRotationBundle(caSecret *corev1.Secret, next *corev1.Secret, caConfig *caConfig, config *signerConfig) error {
+	var caCerts []*x509.Certificate
+	for _, secret := range []*corev1.Secret{caSecret, next} {
+		caCert, err := parseCertificate(secret.Data["tls.crt"])
+		if err != nil {
+			return err
+		}
+		caCerts = append(caCerts, caCert)
+	}
+	return r.ensureCABundle(caConfig.BundleConfigMapName, config.Settings, caCerts...)
+}
+
+// getEtcdStartTime returns when the etcd container called containerName of pod was last
+// started, or nil when it is not running. The only container of a pod is its etcd container.
+func getEtcdStartTime(pod *corev1.Pod, containerName string) *metav1.Time {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name != containerName && len(pod.Status.ContainerStatuses) > 1 {
+			continue
+		}
+		if status.State.Running == nil {
+			return nil
+		}
+		return &status.State.Running.StartedAt
+	}
+	return nil
+}
+
+// getRotationCARoots returns the PEM encoded roots of the CA held by the secret called
+// caSecretName in namespace and of the new CA replacing it, which issued secrets trust while the
+// CA is rotated.
+func (r *EtcdCertSigner) getRotationCARoots(caSecretName string, namespace string) ([]byte, error) {
+	var roots []byte
+	for _, name := range []string{caSecretName, getNextCASecretName(caSecretName)} {
+		secret, err := r.getSecret(name, namespace)
+		if err != nil {
+			return nil, err
+		}
+		root, err := getCARootPEM(secret)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, root...)
+	}
+	return roots, nil
+}
+
+// updateCARotationStatus records phase and message in the status ConfigMap of the rotation of
+// the CA held by caSecret, creating it when status is nil.
+func (r *EtcdCertSigner) updateCARotationStatus(caSecret *corev1.Secret, status *corev1.ConfigMap, phase caRotationPhase, message string) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	if status == nil {
+		return r.client.Create(context.TODO(), &corev1.ConfigMap{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: corev1.SchemeGroupVersion.String(),
+				Kind:       "ConfigMap",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      getCARotationStatusName(caSecret.Name),
+				Namespace: caSecret.Namespace,
+			},
+			Data: map[string]string{
+				caRotationPhaseKey:      string(phase),
+				caRotationMessageKey:    message,
+				caRotationStartedKey:    now,
+				caRotationTransitionKey: now,
+			},
+		})
+	}
+
+	oldPhase := caRotationPhase(status.Data[caRotationPhaseKey])
+	if oldPhase == phase && status.Data[caRotationMessageKey] == message {
+		return nil
+	}
+	if status.Data == nil {
+		status.Data = make(map[string]string)
+	}
+	if oldPhase != phase {
+		if oldPhase == CARotationCompleted {
+			// A new rotation was requested
+			status.Data[caRotationStartedKey] = now
+		}
+		status.Data[caRotationTransitionKey] = now
+	}
+	status.Data[caRotationPhaseKey] = string(phase)
+	status.Data[caRotationMessageKey] = message
+	return r.client.Update(context.TODO(), status)
+}
+
+// getEtcdPods returns the pods in namespace selected as etcd members by settings, in all the
+// watched namespaces when namespace is empty.
+func (r *EtcdCertSigner) getEtcdPods(namespace string, settings *operatorSettings) ([]corev1.Pod, error) {
+	podList := &corev1.PodList{}
+	opts := &client.ListOptions{Namespace: namespace, LabelSelector: settings.podSelector}
+	if err := r.client.List(context.TODO(), opts, podList); err != nil {
+		return nil, err
+	}
+	return podList.Items, nil
+}
+
+// getMemberNamespaces returns the namespaces of the etcd pods selected by settings, which the CA
+// bundles and CRLs are published to.
+func (r *EtcdCertSigner) getMemberNamespaces(settings *operatorSettings) ([]string, error) {
+	pods, err := r.getEtcdPods("", settings)
+	if err != nil {
+		return nil, err
+	}
+	namespaces := sets.NewString()
+	for _, pod := range pods {
+		namespaces.Insert(pod.Namespace)
+	}
+	return namespaces.List(), nil
+}
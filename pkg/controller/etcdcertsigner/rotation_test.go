@@ -0,0 +1,239 @@
+package etcdcertsigner
+
+import (
+	"context"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	etcdv1alpha1 "github.com/alaypatel07/etcd-cert-signer/pkg/apis/etcd/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestEtcdCertSigner_reconcileCARotation(t *testing.T) {
+	const namespace = "etcd-namespace"
+	caConfig, _ := defaultSignerConfig().getCAConfig(etcdCASecretName)
+	caSecret := newTestCASecret(t, etcdCASecretName, namespace)
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "etcd-0",
+			Namespace: namespace,
+			Labels:    map[string]string{"k8s-app": "etcd"},
+		},
+		Status: corev1.PodStatus{
+			StartTime: &metav1.Time{Time: time.Now().Add(-time.Hour)},
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "etcd", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: metav1.Time{Time: time.Now().Add(-time.Hour)}}}},
+				{Name: "etcd-metrics", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: metav1.Time{Time: time.Now().Add(-time.Hour)}}}},
+			},
+		},
+	}
+	peerSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "etcd-0-peer",
+			Namespace: namespace,
+			Annotations: map[string]string{
+				CertificateHostnames:    "etcd-0.etcd.test",
+				CertificateEtcdIdentity: "system:peer:etcd-0",
+			},
+		},
+	}
+	r := EtcdCertSigner{
+		client: fake.NewFakeClient(caSecret, pod, peerSecret),
+		scheme: nil,
+	}
+	config := defaultSignerConfig()
+	peerProfile := defaultProfiles(defaultOperatorSettings())[PeerProfile]
+
+	reconcile := func() (*corev1.Secret, bool) {
+		ca, rotating, err := r.getCASecret(etcdCASecretName, namespace, config)
+		if err != nil {
+			t.Fatalf("getCASecret() error = %v", err)
+		}
+		secret, err := r.getSecret(peerSecret.Name, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var caBundle []byte
+		if rotating {
+			if caBundle, err = r.getRotationCARoots(etcdCASecretName, namespace); err != nil {
+				t.Fatalf("getRotationCARoots() error = %v", err)
+			}
+		}
+		if _, err := r.ensureCertificate(ca, secret, &certificateRequest{profile: &peerProfile, hostnames: []string{"etcd-0.etcd.test"}, identity: "system:peer:etcd-0", caBundle: caBundle}); err != nil {
+			t.Fatalf("ensureCertificate() error = %v", err)
+		}
+		return ca, rotating
+	}
+	phase := func() caRotationPhase {
+		status, err := r.getConfigMap(getCARotationStatusName(etcdCASecretName), namespace)
+		if err != nil {
+			t.Fatalf("CA rotation status missing: %v", err)
+		}
+		return caRotationPhase(status.Data[caRotationPhaseKey])
+	}
+	bundleSize := func() int {
+		bundle, err := r.getConfigMap(caConfig.BundleConfigMapName, namespace)
+		if err != nil {
+			t.Fatalf("CA bundle missing: %v", err)
+		}
+		return countCertificates([]byte(bundle.Data[CABundleKey]))
+	}
+	trustedRoots := func() int {
+		secret, err := r.getSecret(peerSecret.Name, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return countCertificates(secret.Data[CARootKey])
+	}
+
+	// No rotation requested
+	if _, rotating := reconcile(); rotating {
+		t.Fatalf("getCASecret() rotating without a request")
+	}
+	oldCA, _ := r.getSecret(etcdCASecretName, namespace)
+
+	oldCA.Annotations = map[string]string{CARotationAnnotation: "true"}
+	if err := r.client.Update(context.TODO(), oldCA); err != nil {
+		t.Fatal(err)
+	}
+	signing, rotating := reconcile()
+	if !rotating || phase() != CARotationTrustDistributed {
+		t.Fatalf("rotation not started, rotating = %v, phase = %v", rotating, phase())
+	}
+	if string(signing.Data["tls.crt"]) != string(oldCA.Data["tls.crt"]) {
+		t.Errorf("new CA used before the members trust it")
+	}
+	if got := bundleSize(); got != 2 {
+		t.Errorf("CA bundle holds %d certificates, want 2", got)
+	}
+	if got := trustedRoots(); got != 2 {
+		t.Errorf("member secret trusts %d roots while the new CA is distributed, want 2", got)
+	}
+
+	// The member did not restart yet
+	if reconcile(); phase() != CARotationTrustDistributed {
+		t.Errorf("phase = %v while members do not trust the new CA", phase())
+	}
+
+	// Only a restart of the etcd container makes it load the bundle
+	pod.Status.ContainerStatuses[1].State.Running.StartedAt = metav1.Time{Time: time.Now().Add(time.Hour)}
+	if err := r.client.Update(context.TODO(), pod); err != nil {
+		t.Fatal(err)
+	}
+	if reconcile(); phase() != CARotationTrustDistributed {
+		t.Errorf("phase = %v after a restart of another container", phase())
+	}
+
+	pod.Status.ContainerStatuses[0].State.Running.StartedAt = metav1.Time{Time: time.Now().Add(time.Hour)}
+	if err := r.client.Update(context.TODO(), pod); err != nil {
+		t.Fatal(err)
+	}
+	next, _ := r.getSecret(getNextCASecretName(etcdCASecretName), namespace)
+	signing, _ = reconcile()
+	if phase() != CARotationResigning || string(signing.Data["tls.crt"]) != string(next.Data["tls.crt"]) {
+		t.Fatalf("phase = %v, want re-signing with the new CA", phase())
+	}
+	// As exported by the reconcile of the member while re-signing
+	certificateMetrics.setCA(namespace, next.Name, parseTestCert(t, next.Data["tls.crt"]))
+	secret, _ := r.getSecret(peerSecret.Name, namespace)
+	if !isSignedBy(secret, next) {
+		t.Errorf("member secret not re-signed with the new CA")
+	}
+	if got := countCertificates(secret.Data[CARootKey]); got != 2 {
+		t.Errorf("re-signed member secret trusts %d roots, want 2", got)
+	}
+
+	if _, rotating = reconcile(); rotating || phase() != CARotationCompleted {
+		t.Fatalf("rotation not completed, rotating = %v, phase = %v", rotating, phase())
+	}
+	ca, _ := r.getSecret(etcdCASecretName, namespace)
+	if string(ca.Data["tls.crt"]) != string(next.Data["tls.crt"]) {
+		t.Errorf("CA secret not replaced with the new CA")
+	}
+	if _, ok := ca.Annotations[CARotationAnnotation]; ok {
+		t.Errorf("rotation request not removed from the CA secret")
+	}
+	if _, err := r.getSecret(next.Name, namespace); !errors.IsNotFound(err) {
+		t.Errorf("new CA secret not removed, error = %v", err)
+	}
+	if got := bundleSize(); got != 1 {
+		t.Errorf("CA bundle holds %d certificates, want 1", got)
+	}
+	if got := trustedRoots(); got != 1 {
+		t.Errorf("member secret trusts %d roots after the rotation, want 1", got)
+	}
+	if _, ok := certificateMetrics.cas[types.NamespacedName{Namespace: namespace, Name: next.Name}]; ok {
+		t.Errorf("new CA secret still exported after the rotation")
+	}
+}
+
+func countCertificates(bundle []byte) int {
+	var count int
+	for {
+		var block *pem.Block
+		block, bundle = pem.Decode(bundle)
+		if block == nil {
+			return count
+		}
+		count++
+	}
+}
+
+func TestEtcdCertSigner_countSecretsNotSignedBy(t *testing.T) {
+	const namespace = "etcd-namespace"
+	old, next := newTestCA(t, true), newTestCA(t, true)
+	signedSecret := func(name string, ca *signingCA, owner *metav1.OwnerReference) *corev1.Secret {
+		certPEM, _, err := ca.makeServerCert([]string{"etcd-0.etcd.test"}, time.Hour, KeyAlgorithmECDSAP256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Data:       map[string][]byte{"tls.crt": certPEM.Bytes()},
+		}
+		if owner != nil {
+			secret.OwnerReferences = []metav1.OwnerReference{*owner}
+		}
+		return secret
+	}
+	newEtcdCertificate := func(name string, profile string) *etcdv1alpha1.EtcdCertificate {
+		return &etcdv1alpha1.EtcdCertificate{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, UID: types.UID(name + "-uid")},
+			Spec:       etcdv1alpha1.EtcdCertificateSpec{SecretName: name, Profile: profile},
+		}
+	}
+	controllerRef := func(instance *etcdv1alpha1.EtcdCertificate) *metav1.OwnerReference {
+		return metav1.NewControllerRef(instance, etcdv1alpha1.SchemeGroupVersion.WithKind("EtcdCertificate"))
+	}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "etcd-0", Namespace: namespace, Labels: map[string]string{"k8s-app": "etcd"}}}
+	client := newEtcdCertificate("apiserver-etcd-client", ClientProfile)
+	metrics := newEtcdCertificate("etcd-metrics-client", MetricsProfile)
+	conflicting := newEtcdCertificate("conflicting", ClientProfile)
+	nextSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: getNextCASecretName(etcdCASecretName), Namespace: etcdCASecretNamespace},
+		Data:       map[string][]byte{"tls.crt": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: next.cert.Raw})},
+	}
+
+	r := &EtcdCertSigner{client: fake.NewFakeClient(pod, client, metrics, conflicting,
+		signedSecret("etcd-0-peer", old, nil),
+		signedSecret("etcd-0-server", next, nil),
+		signedSecret(client.Spec.SecretName, old, controllerRef(client)),
+		// Not signed by the rotated CA
+		signedSecret(metrics.Spec.SecretName, old, controllerRef(metrics)),
+		// Not controlled by its EtcdCertificate
+		signedSecret(conflicting.Spec.SecretName, old, nil),
+	)}
+	got, err := r.countSecretsNotSignedBy(etcdCASecretName, nextSecret, defaultSignerConfig())
+	if err != nil {
+		t.Fatalf("countSecretsNotSignedBy() error = %v", err)
+	}
+	// The peer secret and the secret of the client EtcdCertificate
+	if got != 2 {
+		t.Errorf("countSecretsNotSignedBy() = %d, want 2", got)
+	}
+}
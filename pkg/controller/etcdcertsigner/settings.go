@@ -0,0 +1,178 @@
+package etcdcertsigner
+
+import (
+	"context"
+	"net/url"
+
+	etcdv1alpha1 "github.com/alaypatel07/etcd-cert-signer/pkg/apis/etcd/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// etcdCASecretName is the default secret holding the CA of the peer, server and client profiles.
+	etcdCASecretName = "etcd-ca"
+	// etcdMetricCASecretName is the default secret holding the CA of the metrics profile.
+	etcdMetricCASecretName = "etcd-metric-ca"
+	// etcdCASecretNamespace is the default namespace of the CA secrets.
+	etcdCASecretNamespace = "openshift-etcd"
+)
+
+// operatorSettings are the operator-wide settings of the EtcdCertSignerConfig singleton, with
+// the fields left empty defaulted.
+type operatorSettings struct {
+	etcdv1alpha1.EtcdCertSignerConfigSpec
+	// podSelector is the parsed PodSelector.
+	podSelector labels.Selector
+}
+
+// defaultOperatorSettings returns the settings used when there is no EtcdCertSignerConfig.
+func defaultOperatorSettings() *operatorSettings {
+	settings, _ := newOperatorSettings(&etcdv1alpha1.EtcdCertSignerConfigSpec{})
+	return settings
+}
+
+// newOperatorSettings returns the settings of spec. A BadRequest error is returned when spec is invalid.
+func newOperatorSettings(spec *etcdv1alpha1.EtcdCertSignerConfigSpec) (*operatorSettings, error) {
+	s := &operatorSettings{EtcdCertSignerConfigSpec: *spec.DeepCopy()}
+	if s.CASecretName == "" {
+		s.CASecretName = etcdCASecretName
+	}
+	if s.MetricsCASecretName == "" {
+		s.MetricsCASecretName = etcdMetricCASecretName
+	}
+	if s.CASecretNamespace == "" {
+		s.CASecretNamespace = etcdCASecretNamespace
+	}
+	if s.PodSelector == nil {
+		s.PodSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"k8s-app": "etcd"}}
+	}
+	if s.EtcdContainerName == "" {
+		s.EtcdContainerName = "etcd"
+	}
+	if s.PeerSecretSuffix == "" {
+		s.PeerSecretSuffix = "-peer"
+	}
+	if s.ServerSecretSuffix == "" {
+		s.ServerSecretSuffix = "-server"
+	}
+	if s.MetricsSecretSuffix == "" {
+		s.MetricsSecretSuffix = "-metrics"
+	}
+	if s.CertificateValidity == nil {
+		s.CertificateValidity = &metav1.Duration{Duration: EtcdCertValidity}
+	}
+	if s.CAValidity == nil {
+		s.CAValidity = &metav1.Duration{Duration: EtcdCAValidity}
+	}
+	if s.MemberSecretPolicy == "" {
+		s.MemberSecretPolicy = etcdv1alpha1.MemberSecretPolicyDeleteWithStatefulSet
+	}
+
+	if s.CertificateValidity.Duration <= 0 || s.CAValidity.Duration <= 0 {
+		return nil, errors.NewBadRequest("Certificate and CA validities must be positive")
+	}
+	// The member secrets of a pod would overwrite each other
+	if sets.NewString(s.PeerSecretSuffix, s.ServerSecretSuffix, s.MetricsSecretSuffix).Len() != 3 {
+		return nil, errors.NewBadRequest("Peer, server and metrics secret suffixes must differ")
+	}
+	if err := validateMemberSecretPolicy(s.MemberSecretPolicy); err != nil {
+		return nil, err
+	}
+	if s.OCSPURL != "" {
+		if u, err := url.Parse(s.OCSPURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, errors.NewBadRequest("OCSP URL must be an absolute http URL")
+		}
+	}
+	selector, err := metav1.LabelSelectorAsSelector(s.PodSelector)
+	if err != nil {
+		return nil, errors.NewBadRequest("Invalid pod selector: " + err.Error())
+	}
+	if selector.Empty() {
+		// Every pod of the namespace would get etcd member certificates
+		return nil, errors.NewBadRequest("Pod selector must not be empty")
+	}
+	s.podSelector = selector
+	return s, nil
+}
+
+// getOperatorSettings returns the settings of the EtcdCertSignerConfig singleton, or the defaults
+// when it does not exist. Settings are read on every reconcile so that changes apply without a restart.
+func (r *EtcdCertSigner) getOperatorSettings() (*operatorSettings, error) {
+	config := &etcdv1alpha1.EtcdCertSignerConfig{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: etcdv1alpha1.EtcdCertSignerConfigName}, config)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return defaultOperatorSettings(), nil
+		}
+		return nil, err
+	}
+	settings, err := newOperatorSettings(&config.Spec)
+	if err != nil {
+		return nil, errors.NewBadRequest("Invalid EtcdCertSignerConfig " + config.Name + ": " + err.Error())
+	}
+	return settings, nil
+}
+
+// LoadOperatorSettings reads the EtcdCertSignerConfig singleton with c and returns its spec with
+// the defaults applied, so that the operator refuses to start with invalid settings rather than
+// failing every reconcile.
+func LoadOperatorSettings(c client.Client) (*etcdv1alpha1.EtcdCertSignerConfigSpec, error) {
+	settings, err := (&EtcdCertSigner{client: c}).getOperatorSettings()
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Loaded operator settings", "CASecretNamespace", settings.CASecretNamespace, "CASecretName", settings.CASecretName,
+		"MetricsCASecretName", settings.MetricsCASecretName, "PodSelector", settings.podSelector.String())
+	return &settings.EtcdCertSignerConfigSpec, nil
+}
+
+// isEtcdPod returns whether the pod labelled with podLabels is an etcd member.
+func (s *operatorSettings) isEtcdPod(podLabels map[string]string) bool {
+	return s.podSelector.Matches(labels.Set(podLabels))
+}
+
+// getMemberSecretPolicy returns the member secret policy of pod, which its annotation overrides.
+func (s *operatorSettings) getMemberSecretPolicy(pod *corev1.Pod) (etcdv1alpha1.MemberSecretPolicy, error) {
+	policy, ok := pod.GetAnnotations()[MemberSecretPolicyAnnotation]
+	if !ok {
+		return s.MemberSecretPolicy, nil
+	}
+	if err := validateMemberSecretPolicy(etcdv1alpha1.MemberSecretPolicy(policy)); err != nil {
+		return "", err
+	}
+	return etcdv1alpha1.MemberSecretPolicy(policy), nil
+}
+
+// validateMemberSecretPolicy returns a BadRequest error when policy is unknown.
+func validateMemberSecretPolicy(policy etcdv1alpha1.MemberSecretPolicy) error {
+	switch policy {
+	case etcdv1alpha1.MemberSecretPolicyDeleteWithPod, etcdv1alpha1.MemberSecretPolicyDeleteWithStatefulSet,
+		etcdv1alpha1.MemberSecretPolicyRevoke, etcdv1alpha1.MemberSecretPolicyRetain:
+		return nil
+	}
+	return errors.NewBadRequest("Unknown member secret policy " + string(policy))
+}
+
+// getOCSPServers returns the OCSP responders advertised by the certificates signed by the CA held
+// by caSecretName. The responder only answers for the CA of CASecretName.
+func (s *operatorSettings) getOCSPServers(caSecretName string) []string {
+	if s.OCSPURL == "" || caSecretName != s.CASecretName {
+		return nil
+	}
+	return []string{s.OCSPURL}
+}
+
+// getMemberSecrets returns the secrets holding the certificates of the etcd member pod.
+func (s *operatorSettings) getMemberSecrets(pod *corev1.Pod) []memberSecret {
+	return []memberSecret{
+		{name: pod.Name + s.PeerSecretSuffix, role: "peer", profile: PeerProfile},
+		{name: pod.Name + s.ServerSecretSuffix, role: "server", profile: ServerProfile},
+		{name: pod.Name + s.MetricsSecretSuffix, role: "metrics", profile: MetricsProfile},
+	}
+}
@@ -0,0 +1,188 @@
+package etcdcertsigner
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alaypatel07/etcd-cert-signer/pkg/apis"
+	etcdv1alpha1 "github.com/alaypatel07/etcd-cert-signer/pkg/apis/etcd/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func init() {
+	// The fake clients of the tests are built with the client-go scheme
+	if err := apis.AddToScheme(scheme.Scheme); err != nil {
+		panic(err)
+	}
+}
+
+func Test_operatorSettings_isEtcdPod(t *testing.T) {
+	type args struct {
+		labels map[string]string
+	}
+	tests := []struct {
+		name string
+		args args
+		want bool
+	}{
+		{
+			name: "Etcd pod",
+			args: args{labels: map[string]string{
+				"k8s-app": "etcd",
+			}},
+			want: true,
+		},
+		{
+			name: "Etcd pod with other labels",
+			args: args{labels: map[string]string{
+				"k8s-app": "etcd",
+				"foo":     "bar",
+				"_":       "_",
+			}},
+			want: true,
+		},
+		{
+			name: "Not an etcd pod",
+			args: args{labels: map[string]string{
+				"not-k8s-app": "etcd",
+				"foo":         "bar",
+			}},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := defaultOperatorSettings().isEtcdPod(tt.args.labels); got != tt.want {
+				t.Errorf("isEtcdPod() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_newOperatorSettings(t *testing.T) {
+	tests := []struct {
+		name           string
+		spec           etcdv1alpha1.EtcdCertSignerConfigSpec
+		wantCA         string
+		wantMembers    []string
+		wantValidity   time.Duration
+		wantEtcdPod    map[string]string
+		wantBadRequest bool
+	}{
+		{
+			name:         "Defaults",
+			wantCA:       etcdCASecretName,
+			wantMembers:  []string{"etcd-0-peer", "etcd-0-server", "etcd-0-metrics"},
+			wantValidity: EtcdCertValidity,
+			wantEtcdPod:  map[string]string{"k8s-app": "etcd"},
+		},
+		{
+			name: "Configured settings",
+			spec: etcdv1alpha1.EtcdCertSignerConfigSpec{
+				CASecretName:        "etcd-signer",
+				PodSelector:         &metav1.LabelSelector{MatchLabels: map[string]string{"app": "etcd"}},
+				PeerSecretSuffix:    "-peer-tls",
+				ServerSecretSuffix:  "-serving-tls",
+				CertificateValidity: &metav1.Duration{Duration: 24 * time.Hour},
+			},
+			wantCA:       "etcd-signer",
+			wantMembers:  []string{"etcd-0-peer-tls", "etcd-0-serving-tls", "etcd-0-metrics"},
+			wantValidity: 24 * time.Hour,
+			wantEtcdPod:  map[string]string{"app": "etcd"},
+		},
+		{
+			name:           "Same peer and server suffix",
+			spec:           etcdv1alpha1.EtcdCertSignerConfigSpec{ServerSecretSuffix: "-peer"},
+			wantBadRequest: true,
+		},
+		{
+			name:           "Negative CA validity",
+			spec:           etcdv1alpha1.EtcdCertSignerConfigSpec{CAValidity: &metav1.Duration{Duration: -time.Hour}},
+			wantBadRequest: true,
+		},
+		{
+			name:           "Unknown member secret policy",
+			spec:           etcdv1alpha1.EtcdCertSignerConfigSpec{MemberSecretPolicy: "Orphan"},
+			wantBadRequest: true,
+		},
+		{
+			name:           "Relative OCSP URL",
+			spec:           etcdv1alpha1.EtcdCertSignerConfigSpec{OCSPURL: "etcd-cert-signer:8889"},
+			wantBadRequest: true,
+		},
+		{
+			name:           "Empty pod selector",
+			spec:           etcdv1alpha1.EtcdCertSignerConfigSpec{PodSelector: &metav1.LabelSelector{}},
+			wantBadRequest: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newOperatorSettings(&tt.spec)
+			if tt.wantBadRequest {
+				if !errors.IsBadRequest(err) {
+					t.Errorf("newOperatorSettings() error = %v, want a BadRequest", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newOperatorSettings() error = %v", err)
+			}
+			if got.CASecretName != tt.wantCA {
+				t.Errorf("newOperatorSettings() CASecretName = %v, want %v", got.CASecretName, tt.wantCA)
+			}
+			if got.CertificateValidity.Duration != tt.wantValidity {
+				t.Errorf("newOperatorSettings() CertificateValidity = %v, want %v", got.CertificateValidity.Duration, tt.wantValidity)
+			}
+			if !got.isEtcdPod(tt.wantEtcdPod) {
+				t.Errorf("newOperatorSettings() does not select pods labelled %v", tt.wantEtcdPod)
+			}
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "etcd-0"}}
+			members := got.getMemberSecrets(pod)
+			for i, name := range tt.wantMembers {
+				if members[i].name != name {
+					t.Errorf("getMemberSecrets()[%d] = %v, want %v", i, members[i].name, name)
+				}
+			}
+		})
+	}
+}
+
+func TestEtcdCertSigner_getSignerConfig_settings(t *testing.T) {
+	config := &etcdv1alpha1.EtcdCertSignerConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: etcdv1alpha1.EtcdCertSignerConfigName},
+		Spec: etcdv1alpha1.EtcdCertSignerConfigSpec{
+			CASecretName:        "etcd-signer",
+			MetricsCASecretName: "etcd-metrics-signer",
+			CAValidity:          &metav1.Duration{Duration: 24 * time.Hour},
+		},
+	}
+	r := &EtcdCertSigner{client: fake.NewFakeClient(config)}
+	got, err := r.getSignerConfig("etcd-namespace")
+	if err != nil {
+		t.Fatalf("getSignerConfig() error = %v", err)
+	}
+	if ca := got.Profiles[PeerProfile].CASecretName; ca != "etcd-signer" {
+		t.Errorf("getSignerConfig() peer CA = %v, want etcd-signer", ca)
+	}
+	if ca := got.Profiles[MetricsProfile].CASecretName; ca != "etcd-metrics-signer" {
+		t.Errorf("getSignerConfig() metrics CA = %v, want etcd-metrics-signer", ca)
+	}
+	caConfig, _ := got.getCAConfig("etcd-signer")
+	if caConfig.Subject.CommonName != "etcd-signer" || caConfig.Validity.Duration != 24*time.Hour {
+		t.Errorf("getSignerConfig() CA subject = %v, validity = %v", caConfig.Subject, caConfig.Validity.Duration)
+	}
+
+	config.Spec.ServerSecretSuffix = "-peer"
+	if err := r.client.Update(context.TODO(), config); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.getSignerConfig("etcd-namespace"); !errors.IsBadRequest(err) {
+		t.Errorf("getSignerConfig() error = %v, want a BadRequest for invalid settings", err)
+	}
+}
@@ -0,0 +1,460 @@
+package etcdcertsigner
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	// KeyAlgorithmRSA2048 generates 2048 bit RSA keys encoded as PKCS#1.
+	KeyAlgorithmRSA2048 = "rsa-2048"
+	// KeyAlgorithmRSA3072 generates 3072 bit RSA keys encoded as PKCS#1.
+	KeyAlgorithmRSA3072 = "rsa-3072"
+	// KeyAlgorithmRSA4096 generates 4096 bit RSA keys encoded as PKCS#1.
+	KeyAlgorithmRSA4096 = "rsa-4096"
+	// KeyAlgorithmECDSAP256 generates ECDSA keys on the P-256 curve encoded as SEC1.
+	KeyAlgorithmECDSAP256 = "ecdsa-p256"
+	// KeyAlgorithmECDSAP384 generates ECDSA keys on the P-384 curve encoded as SEC1.
+	KeyAlgorithmECDSAP384 = "ecdsa-p384"
+	// KeyAlgorithmEd25519 generates Ed25519 keys encoded as PKCS#8.
+	KeyAlgorithmEd25519 = "ed25519"
+)
+
+// signingCA is a certificate authority loaded from a CA secret.
+type signingCA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+	// chain holds the certificates above cert when it is an intermediate, ordered from its
+	// issuer up to the root.
+	chain []*x509.Certificate
+}
+
+// certificateTemplateFunc modifies a leaf certificate template before it is signed. The PublicKey
+// of the template is the key the certificate is signed for.
+type certificateTemplateFunc func(*x509.Certificate) error
+
+// defaultKeyUsage returns the key usage of the certificates for publicKey: digital signature, and
+// key encipherment for RSA keys only, as ECDSA and Ed25519 keys cannot encrypt a TLS key exchange.
+func defaultKeyUsage(publicKey crypto.PublicKey) x509.KeyUsage {
+	if _, ok := publicKey.(*rsa.PublicKey); ok {
+		return x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	}
+	return x509.KeyUsageDigitalSignature
+}
+
+// loadSigningCA parses the PEM encoded certificate and private key of a CA.
+func loadSigningCA(certPEM []byte, keyPEM []byte) (*signingCA, error) {
+	cert, err := parseCertificate(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKeyMatches(cert, key); err != nil {
+		return nil, err
+	}
+	return &signingCA{cert: cert, key: key}, nil
+}
+
+// checkKeyMatches returns a BadRequest error when key is not the private key of cert.
+func checkKeyMatches(cert *x509.Certificate, key crypto.Signer) error {
+	certPublicKey, err := subjectPublicKeyBytes(cert.PublicKey)
+	if err != nil {
+		return err
+	}
+	publicKey, err := subjectPublicKeyBytes(key.Public())
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(certPublicKey, publicKey) {
+		return errors.NewBadRequest("Private key does not match the certificate")
+	}
+	return nil
+}
+
+// setChain verifies that the PEM encoded certificates in chainPEM lead from the CA certificate to
+// a root and uses them as the chain of the CA. The CA certificate itself may be included.
+func (ca *signingCA) setChain(chainPEM []byte) error {
+	certs, err := parseCertificates(chainPEM)
+	if err != nil {
+		return err
+	}
+	var chain []*x509.Certificate
+	for _, cert := range certs {
+		if !cert.Equal(ca.cert) {
+			chain = append(chain, cert)
+		}
+	}
+	child := ca.cert
+	for _, issuer := range chain {
+		if err := child.CheckSignatureFrom(issuer); err != nil {
+			return errors.NewBadRequest("CA chain is not ordered from the CA to the root: " + err.Error())
+		}
+		child = issuer
+	}
+	ca.chain = chain
+	return nil
+}
+
+// root returns the root of the CA, which is the CA certificate itself when it has no chain.
+func (ca *signingCA) root() *x509.Certificate {
+	if len(ca.chain) == 0 {
+		return ca.cert
+	}
+	return ca.chain[len(ca.chain)-1]
+}
+
+// issuers returns the certificates sent after an issued leaf: the CA certificate and the
+// intermediates above it. The root of a chain is left out since peers must already trust it.
+func (ca *signingCA) issuers() []*x509.Certificate {
+	issuers := append([]*x509.Certificate{ca.cert}, ca.chain...)
+	if len(ca.chain) > 0 && isSelfSigned(ca.root()) {
+		issuers = issuers[:len(issuers)-1]
+	}
+	return issuers
+}
+
+// verify checks that cert is valid and chains to the root of the CA through the CA certificate.
+func (ca *signingCA) verify(cert *x509.Certificate) error {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.root())
+	intermediates := x509.NewCertPool()
+	for _, issuer := range append([]*x509.Certificate{ca.cert}, ca.chain...) {
+		if issuer != ca.root() {
+			intermediates.AddCert(issuer)
+		}
+	}
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
+
+// parseCertificates parses all certificates in certPEM.
+func parseCertificates(certPEM []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, certPEM = pem.Decode(certPEM)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, errors.NewBadRequest("Unexpected PEM type " + block.Type + " in certificate chain")
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.NewBadRequest("Unable to decode certificate PEM")
+	}
+	return certs, nil
+}
+
+// parsePrivateKey parses a PEM encoded PKCS#1, SEC1 or PKCS#8 private key.
+func parsePrivateKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.NewBadRequest("Unable to decode private key PEM")
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.NewBadRequest("Unsupported private key type")
+		}
+		return signer, nil
+	}
+	return nil, errors.NewBadRequest("Unsupported private key PEM type " + block.Type)
+}
+
+// generateKey generates a private key with one of the supported key algorithms.
+func generateKey(keyAlgorithm string) (crypto.Signer, error) {
+	switch keyAlgorithm {
+	case KeyAlgorithmRSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case KeyAlgorithmRSA3072:
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case KeyAlgorithmRSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case KeyAlgorithmECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyAlgorithmECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyAlgorithmEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, errors.NewBadRequest("Unsupported key algorithm " + keyAlgorithm)
+}
+
+// getKeyAlgorithm returns the supported key algorithm of publicKey, or "" when it is not supported.
+func getKeyAlgorithm(publicKey crypto.PublicKey) string {
+	switch k := publicKey.(type) {
+	case *rsa.PublicKey:
+		switch k.N.BitLen() {
+		case 2048:
+			return KeyAlgorithmRSA2048
+		case 3072:
+			return KeyAlgorithmRSA3072
+		case 4096:
+			return KeyAlgorithmRSA4096
+		}
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return KeyAlgorithmECDSAP256
+		case elliptic.P384():
+			return KeyAlgorithmECDSAP384
+		}
+	case ed25519.PublicKey:
+		return KeyAlgorithmEd25519
+	}
+	return ""
+}
+
+// validateKeyAlgorithm checks that keyAlgorithm is one of the supported key algorithms.
+func validateKeyAlgorithm(keyAlgorithm string) error {
+	switch keyAlgorithm {
+	case KeyAlgorithmRSA2048, KeyAlgorithmRSA3072, KeyAlgorithmRSA4096,
+		KeyAlgorithmECDSAP256, KeyAlgorithmECDSAP384, KeyAlgorithmEd25519:
+		return nil
+	}
+	return errors.NewBadRequest("Unsupported key algorithm " + keyAlgorithm)
+}
+
+// encodePrivateKey PEM encodes RSA keys as PKCS#1, ECDSA keys as SEC1 and Ed25519 keys as PKCS#8.
+func encodePrivateKey(key crypto.Signer) (*bytes.Buffer, error) {
+	var block *pem.Block
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	case ed25519.PrivateKey:
+		der, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	default:
+		return nil, errors.NewBadRequest("Unsupported private key type")
+	}
+	keyBytes := &bytes.Buffer{}
+	if err := pem.Encode(keyBytes, block); err != nil {
+		return nil, err
+	}
+	return keyBytes, nil
+}
+
+// makeServerCert generates a new key pair with keyAlgorithm and signs a certificate for hostnames
+// that is valid for lifetime. The returned certificate PEM holds the leaf followed by the CA
+// certificate and its intermediates.
+func (ca *signingCA) makeServerCert(hostnames []string, lifetime time.Duration, keyAlgorithm string, fns ...certificateTemplateFunc) (*bytes.Buffer, *bytes.Buffer, error) {
+	key, err := generateKey(keyAlgorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ips, dnsNames := ipAddressesDNSNames(hostnames)
+	template := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: hostnames[0]},
+		NotBefore:             time.Now().Add(-1 * time.Second),
+		NotAfter:              time.Now().Add(lifetime),
+		PublicKey:             key.Public(),
+		KeyUsage:              defaultKeyUsage(key.Public()),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IPAddresses:           ips,
+		DNSNames:              dnsNames,
+	}
+	for _, fn := range fns {
+		if err := fn(template); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	cert, err := ca.signCertificate(template, key.Public())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certBytes := &bytes.Buffer{}
+	for _, c := range append([]*x509.Certificate{cert}, ca.issuers()...) {
+		if err := pem.Encode(certBytes, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw}); err != nil {
+			return nil, nil, err
+		}
+	}
+	keyBytes, err := encodePrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certBytes, keyBytes, nil
+}
+
+// signRequest signs a certificate for the subject, hostnames and public key of req that is valid
+// for lifetime. The returned certificate PEM holds the leaf followed by the CA certificate and its
+// intermediates.
+func (ca *signingCA) signRequest(req *x509.CertificateRequest, lifetime time.Duration, fns ...certificateTemplateFunc) (*bytes.Buffer, error) {
+	if err := req.CheckSignature(); err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		Subject:               req.Subject,
+		NotBefore:             time.Now().Add(-1 * time.Second),
+		NotAfter:              time.Now().Add(lifetime),
+		PublicKey:             req.PublicKey,
+		KeyUsage:              defaultKeyUsage(req.PublicKey),
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IPAddresses:           req.IPAddresses,
+		DNSNames:              req.DNSNames,
+	}
+	for _, fn := range fns {
+		if err := fn(template); err != nil {
+			return nil, err
+		}
+	}
+
+	cert, err := ca.signCertificate(template, req.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	certBytes := &bytes.Buffer{}
+	for _, c := range append([]*x509.Certificate{cert}, ca.issuers()...) {
+		if err := pem.Encode(certBytes, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw}); err != nil {
+			return nil, err
+		}
+	}
+	return certBytes, nil
+}
+
+// signCertificate signs template for publicKey with a random serial number, a Subject Key
+// Identifier for publicKey and an Authority Key Identifier for the CA.
+func (ca *signingCA) signCertificate(template *x509.Certificate, publicKey crypto.PublicKey) (*x509.Certificate, error) {
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	template.SerialNumber = serial
+
+	template.SubjectKeyId, err = subjectKeyID(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	template.AuthorityKeyId = ca.cert.SubjectKeyId
+	if len(template.AuthorityKeyId) == 0 {
+		// CAs created without a Subject Key Identifier still get a stable key id
+		template.AuthorityKeyId, err = subjectKeyID(ca.cert.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, publicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// newSerialNumber returns a random 160-bit serial number, the maximum size allowed by RFC 5280.
+// This follows the CFSSL implementation.
+func newSerialNumber() (*big.Int, error) {
+	serialNumber := make([]byte, 20)
+	if _, err := io.ReadFull(rand.Reader, serialNumber); err != nil {
+		return nil, err
+	}
+
+	// SetBytes interprets buf as the bytes of a big-endian
+	// unsigned integer. The leading byte should be masked
+	// off to ensure it isn't negative.
+	serialNumber[0] &= 0x7F
+	return new(big.Int).SetBytes(serialNumber), nil
+}
+
+// subjectKeyID computes the key identifier of publicKey as the SHA-1 hash of the
+// subjectPublicKey bit string (RFC 5280, section 4.2.1.2, method 1).
+func subjectKeyID(publicKey crypto.PublicKey) ([]byte, error) {
+	subjectPublicKey, err := subjectPublicKeyBytes(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	id := sha1.Sum(subjectPublicKey)
+	return id[:], nil
+}
+
+// subjectPublicKeyBytes returns the subjectPublicKey bit string of the DER encoded publicKey.
+func subjectPublicKeyBytes(publicKey crypto.PublicKey) ([]byte, error) {
+	switch publicKey.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+	default:
+		return nil, errors.NewBadRequest("Unsupported public key type")
+	}
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	var spki struct {
+		Algorithm        pkix.AlgorithmIdentifier
+		SubjectPublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(der, &spki); err != nil {
+		return nil, err
+	}
+	return spki.SubjectPublicKey.Bytes, nil
+}
+
+// ipAddressesDNSNames splits hostnames into IP addresses and DNS names.
+func ipAddressesDNSNames(hostnames []string) ([]net.IP, []string) {
+	var ips []net.IP
+	var dnsNames []string
+	for _, hostname := range hostnames {
+		if ip := net.ParseIP(hostname); ip != nil {
+			ips = append(ips, ip)
+		} else {
+			dnsNames = append(dnsNames, hostname)
+		}
+	}
+	return ips, dnsNames
+}
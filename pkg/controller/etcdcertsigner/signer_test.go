@@ -0,0 +1,381 @@
+package etcdcertsigner
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestCA(t *testing.T, withSubjectKeyID bool) *signingCA {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			OrganizationalUnit: []string{"openshift"},
+			CommonName:         "etcd-signer",
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	if withSubjectKeyID {
+		template.SubjectKeyId = []byte{1, 2, 3, 4}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &signingCA{cert: cert, key: key}
+}
+
+// newTestCASecret returns the secret called name in namespace holding a self-signed CA made with
+// the default settings.
+func newTestCASecret(t *testing.T, name string, namespace string) *corev1.Secret {
+	config := caConfig{}
+	config.setDefaults(name, defaultOperatorSettings())
+	certPEM, keyPEM, err := makeSelfSignedCA(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Data: map[string][]byte{
+			"tls.crt": certPEM.Bytes(),
+			"tls.key": keyPEM.Bytes(),
+		},
+	}
+}
+
+func Test_newSerialNumber(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		serial, err := newSerialNumber()
+		if err != nil {
+			t.Fatalf("newSerialNumber() error = %v", err)
+		}
+		if serial.Sign() < 0 {
+			t.Errorf("newSerialNumber() = %v, want a positive serial", serial)
+		}
+		if serial.BitLen() > 159 {
+			t.Errorf("newSerialNumber() bit length = %v, want at most 159", serial.BitLen())
+		}
+		if seen[serial.String()] {
+			t.Errorf("newSerialNumber() = %v, generated twice", serial)
+		}
+		seen[serial.String()] = true
+	}
+}
+
+func Test_signingCA_makeServerCert(t *testing.T) {
+	tests := []struct {
+		name             string
+		withSubjectKeyID bool
+	}{
+		{
+			name:             "CA with Subject Key Identifier",
+			withSubjectKeyID: true,
+		},
+		{
+			name:             "CA without Subject Key Identifier",
+			withSubjectKeyID: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ca := newTestCA(t, tt.withSubjectKeyID)
+			certPEM, keyPEM, err := ca.makeServerCert([]string{"etcd-0.etcd.test", "10.10.10.10"}, time.Hour, KeyAlgorithmRSA2048)
+			if err != nil {
+				t.Fatalf("makeServerCert() error = %v", err)
+			}
+			cert, err := parseCertificate(certPEM.Bytes())
+			if err != nil {
+				t.Fatalf("makeServerCert() cert unparseable: %v", err)
+			}
+			key, err := parsePrivateKey(keyPEM.Bytes())
+			if err != nil {
+				t.Fatalf("makeServerCert() key unparseable: %v", err)
+			}
+
+			wantSKI, _ := subjectKeyID(key.Public())
+			if !bytes.Equal(cert.SubjectKeyId, wantSKI) {
+				t.Errorf("makeServerCert() SubjectKeyId = %x, want %x", cert.SubjectKeyId, wantSKI)
+			}
+			// Newer Go releases generate a Subject Key Identifier for CA templates without one
+			wantAKI := ca.cert.SubjectKeyId
+			if len(wantAKI) == 0 {
+				wantAKI, _ = subjectKeyID(ca.cert.PublicKey)
+			}
+			if !bytes.Equal(cert.AuthorityKeyId, wantAKI) {
+				t.Errorf("makeServerCert() AuthorityKeyId = %x, want %x", cert.AuthorityKeyId, wantAKI)
+			}
+			if err := cert.CheckSignatureFrom(ca.cert); err != nil {
+				t.Errorf("makeServerCert() not signed by CA: %v", err)
+			}
+			if len(cert.DNSNames) != 1 || len(cert.IPAddresses) != 1 {
+				t.Errorf("makeServerCert() DNSNames = %v, IPAddresses = %v", cert.DNSNames, cert.IPAddresses)
+			}
+		})
+	}
+}
+
+func Test_parsePrivateKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8DER, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		block   *pem.Block
+		wantErr bool
+	}{
+		{
+			name:  "PKCS#1 RSA key",
+			block: &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)},
+		},
+		{
+			name:  "SEC1 ECDSA key",
+			block: &pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER},
+		},
+		{
+			name:  "PKCS#8 key",
+			block: &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8DER},
+		},
+		{
+			name:    "Unknown PEM type",
+			block:   &pem.Block{Type: "CERTIFICATE", Bytes: []byte("foo")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parsePrivateKey(pem.EncodeToMemory(tt.block))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parsePrivateKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_signingCA_makeServerCert_keyAlgorithms(t *testing.T) {
+	ca := newTestCA(t, true)
+	tests := []struct {
+		keyAlgorithm string
+		wantPEMType  string
+		wantKeyUsage x509.KeyUsage
+		wantErr      bool
+	}{
+		{keyAlgorithm: KeyAlgorithmRSA2048, wantPEMType: "RSA PRIVATE KEY", wantKeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment},
+		{keyAlgorithm: KeyAlgorithmRSA3072, wantPEMType: "RSA PRIVATE KEY", wantKeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment},
+		{keyAlgorithm: KeyAlgorithmECDSAP256, wantPEMType: "EC PRIVATE KEY", wantKeyUsage: x509.KeyUsageDigitalSignature},
+		{keyAlgorithm: KeyAlgorithmECDSAP384, wantPEMType: "EC PRIVATE KEY", wantKeyUsage: x509.KeyUsageDigitalSignature},
+		{keyAlgorithm: KeyAlgorithmEd25519, wantPEMType: "PRIVATE KEY", wantKeyUsage: x509.KeyUsageDigitalSignature},
+		{keyAlgorithm: "dsa-1024", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.keyAlgorithm, func(t *testing.T) {
+			profile := defaultProfiles(defaultOperatorSettings())[PeerProfile]
+			certPEM, keyPEM, err := ca.makeServerCert([]string{"etcd-0.etcd.test"}, time.Hour, tt.keyAlgorithm, profile.applyExtensions)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("makeServerCert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if block, _ := pem.Decode(keyPEM.Bytes()); block == nil || block.Type != tt.wantPEMType {
+				t.Errorf("makeServerCert() key PEM type = %v, want %v", block, tt.wantPEMType)
+			}
+			cert, err := parseCertificate(certPEM.Bytes())
+			if err != nil {
+				t.Fatalf("makeServerCert() cert unparseable: %v", err)
+			}
+			key, err := parsePrivateKey(keyPEM.Bytes())
+			if err != nil {
+				t.Fatalf("makeServerCert() key unparseable: %v", err)
+			}
+			certPublicKey, _ := x509.MarshalPKIXPublicKey(cert.PublicKey)
+			keyPublicKey, _ := x509.MarshalPKIXPublicKey(key.Public())
+			if !bytes.Equal(certPublicKey, keyPublicKey) {
+				t.Errorf("makeServerCert() key does not match the certificate")
+			}
+			if cert.KeyUsage != tt.wantKeyUsage {
+				t.Errorf("makeServerCert() KeyUsage = %v, want %v", cert.KeyUsage, tt.wantKeyUsage)
+			}
+		})
+	}
+}
+
+func newTestIntermediateCA(t *testing.T, root *signingCA) *signingCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := root.signCertificate(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "etcd-intermediate-signer"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &signingCA{cert: cert, key: key}
+}
+
+func Test_signingCA_setChain(t *testing.T) {
+	root := newTestCA(t, true)
+	intermediate := newTestIntermediateCA(t, root)
+	encode := func(certs ...*x509.Certificate) []byte {
+		chain := &bytes.Buffer{}
+		for _, cert := range certs {
+			pem.Encode(chain, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		}
+		return chain.Bytes()
+	}
+
+	tests := []struct {
+		name      string
+		chainPEM  []byte
+		wantChain int
+		wantErr   bool
+	}{
+		{
+			name:      "Root only",
+			chainPEM:  encode(root.cert),
+			wantChain: 1,
+		},
+		{
+			name:      "Intermediate followed by the root",
+			chainPEM:  encode(intermediate.cert, root.cert),
+			wantChain: 1,
+		},
+		{
+			name:     "Unrelated root",
+			chainPEM: encode(newTestCA(t, true).cert),
+			wantErr:  true,
+		},
+		{
+			name:     "Not PEM",
+			chainPEM: []byte("foo"),
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ca := &signingCA{cert: intermediate.cert, key: intermediate.key}
+			err := ca.setChain(tt.chainPEM)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("setChain() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(ca.chain) != tt.wantChain {
+				t.Errorf("setChain() chain length = %v, want %v", len(ca.chain), tt.wantChain)
+			}
+			if !ca.root().Equal(root.cert) {
+				t.Errorf("root() = %v, want %v", ca.root().Subject, root.cert.Subject)
+			}
+		})
+	}
+}
+
+func Test_signingCA_makeServerCert_intermediate(t *testing.T) {
+	root := newTestCA(t, true)
+	ca := newTestIntermediateCA(t, root)
+	if err := ca.setChain(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.cert.Raw})); err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM, _, err := ca.makeServerCert([]string{"etcd-0.etcd.test"}, time.Hour, KeyAlgorithmECDSAP256)
+	if err != nil {
+		t.Fatalf("makeServerCert() error = %v", err)
+	}
+	certs, err := parseCertificates(certPEM.Bytes())
+	if err != nil {
+		t.Fatalf("makeServerCert() cert unparseable: %v", err)
+	}
+	if len(certs) != 2 || !certs[1].Equal(ca.cert) {
+		t.Fatalf("makeServerCert() returned %d certificates, want the leaf followed by the intermediate", len(certs))
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(certs[1])
+	if _, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates}); err != nil {
+		t.Errorf("makeServerCert() leaf does not chain to the root: %v", err)
+	}
+}
+
+func Test_signingCA_verify(t *testing.T) {
+	root := newTestCA(t, true)
+	ca := newTestIntermediateCA(t, root)
+	if err := ca.setChain(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.cert.Raw})); err != nil {
+		t.Fatal(err)
+	}
+	sibling := newTestIntermediateCA(t, root)
+	other := newTestCA(t, true)
+
+	tests := []struct {
+		name    string
+		issuer  *signingCA
+		wantErr bool
+	}{
+		{name: "Issued by the CA", issuer: ca},
+		{name: "Issued by another intermediate of the root", issuer: sibling, wantErr: true},
+		{name: "Issued by another CA", issuer: other, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certPEM, _, err := tt.issuer.makeServerCert([]string{"etcd-0"}, time.Hour, KeyAlgorithmECDSAP256)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cert, err := parseCertificate(certPEM.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ca.verify(cert); (err != nil) != tt.wantErr {
+				t.Errorf("verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
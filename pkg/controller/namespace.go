@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// namespaceManager is a Manager whose client reads the objects of one more namespace than the
+// Manager watches from a cache of their own.
+type namespaceManager struct {
+	manager.Manager
+	client client.Client
+	cache  cache.Cache
+}
+
+// GetClient returns a client reading the objects of the additional namespace from its cache.
+func (m *namespaceManager) GetClient() client.Client {
+	return m.client
+}
+
+// GetNamespaceCache returns the cache of the additional namespace, for controllers to watch its objects.
+func (m *namespaceManager) GetNamespaceCache() cache.Cache {
+	return m.cache
+}
+
+// WithNamespace returns a Manager like m whose client also reads the objects of namespace from a
+// cache, for the operator to use a namespace outside of the one it watches. The cache is started
+// with m.
+func WithNamespace(m manager.Manager, namespace string) (manager.Manager, error) {
+	c, err := cache.New(m.GetConfig(), cache.Options{Scheme: m.GetScheme(), Namespace: namespace})
+	if err != nil {
+		return nil, err
+	}
+	if err := m.Add(c); err != nil {
+		return nil, err
+	}
+	return &namespaceManager{
+		Manager: m,
+		client:  &namespaceClient{Client: m.GetClient(), namespace: namespace, reader: c},
+		cache:   c,
+	}, nil
+}
+
+// namespaceClient reads the objects of namespace with reader and delegates everything else to Client.
+type namespaceClient struct {
+	client.Client
+	namespace string
+	reader    client.Reader
+}
+
+// Get reads obj from the reader of its namespace.
+func (c *namespaceClient) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	if key.Namespace == c.namespace {
+		return c.reader.Get(ctx, key, obj)
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+// List lists the objects of the namespace of opts from its reader.
+func (c *namespaceClient) List(ctx context.Context, opts *client.ListOptions, list runtime.Object) error {
+	if opts != nil && opts.Namespace == c.namespace {
+		return c.reader.List(ctx, opts, list)
+	}
+	return c.Client.List(ctx, opts, list)
+}
@@ -0,0 +1,44 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNamespaceClient(t *testing.T) {
+	member := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "etcd-0-peer", Namespace: "etcd-namespace"}}
+	ca := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "etcd-ca", Namespace: "ca-namespace"}}
+	c := &namespaceClient{
+		Client:    fake.NewFakeClient(member),
+		namespace: "ca-namespace",
+		reader:    fake.NewFakeClient(ca),
+	}
+
+	for _, key := range []types.NamespacedName{
+		{Namespace: member.Namespace, Name: member.Name},
+		{Namespace: ca.Namespace, Name: ca.Name},
+	} {
+		if err := c.Get(context.TODO(), key, &corev1.Secret{}); err != nil {
+			t.Errorf("Get(%v) error = %v", key, err)
+		}
+	}
+
+	secrets := &corev1.SecretList{}
+	if err := c.List(context.TODO(), client.InNamespace(ca.Namespace), secrets); err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(secrets.Items) != 1 || secrets.Items[0].Name != ca.Name {
+		t.Errorf("List() = %v, want the CA secret", secrets.Items)
+	}
+
+	// Writes go through the client of the manager
+	if err := c.Update(context.TODO(), member); err != nil {
+		t.Errorf("Update() error = %v", err)
+	}
+}
@@ -1,7 +1,28 @@
 #!/usr/bin/env bash
 
+kubectl apply -f deploy/crds/etcd_v1alpha1_etcdcertsignerconfig_crd.yaml
+cat <<EOF | kubectl apply -f -
+apiVersion: etcd.openshift.io/v1alpha1
+kind: EtcdCertSignerConfig
+metadata:
+  name: cluster
+spec:
+  caSecretNamespace: default
+EOF
+
 kubectl create secret tls etcd-ca -n default --cert=./tmp/tls.crt --key=./tmp/tls.key
 
+# The metrics CA key pair is generated locally rather than checked in
+if [ ! -f ./tmp/metrics/tls.key ]; then
+  mkdir -p ./tmp/metrics
+  openssl req -x509 -newkey rsa:2048 -nodes -days 3650 \
+    -subj "/OU=openshift/CN=etcd-metric-signer" \
+    -addext "basicConstraints=critical,CA:TRUE" \
+    -addext "keyUsage=critical,digitalSignature,keyEncipherment,keyCertSign" \
+    -keyout ./tmp/metrics/tls.key -out ./tmp/metrics/tls.crt
+fi
+kubectl create secret tls etcd-metric-ca -n default --cert=./tmp/metrics/tls.crt --key=./tmp/metrics/tls.key
+
 cat <<EOF | kubectl create -f -
 apiVersion: v1
 kind: Secret
@@ -18,12 +39,23 @@ apiVersion: v1
 kind: Secret
 metadata:
   annotations:
-    openshift.io/certificate-hostnames: "localhost,etcd-1,127.0.0.1,172.30.66.10"
+    auth.openshift.io/certificate-hostnames: "localhost,etcd-1,127.0.0.1,172.30.66.10"
     auth.openshift.io/certificate-etcd-identity: "system:server:etcd-1"
   name: etcd-1-server
   namespace: default
 EOF
 
+cat <<EOF | kubectl create -f -
+apiVersion: v1
+kind: Secret
+metadata:
+  annotations:
+    auth.openshift.io/certificate-hostnames: "localhost,etcd-1,127.0.0.1,172.30.66.10"
+    auth.openshift.io/certificate-etcd-identity: "system:metrics:etcd-1"
+  name: etcd-1-metrics
+  namespace: default
+EOF
+
 cat <<EOF | kubectl create -f -
 apiVersion: v1
 kind: Secret
@@ -40,12 +72,23 @@ apiVersion: v1
 kind: Secret
 metadata:
   annotations:
-    openshift.io/certificate-hostnames: "localhost,etcd-2,127.0.0.1,172.30.66.11"
+    auth.openshift.io/certificate-hostnames: "localhost,etcd-2,127.0.0.1,172.30.66.11"
     auth.openshift.io/certificate-etcd-identity: "system:server:etcd-2"
   name: etcd-2-server
   namespace: default
 EOF
 
+cat <<EOF | kubectl create -f -
+apiVersion: v1
+kind: Secret
+metadata:
+  annotations:
+    auth.openshift.io/certificate-hostnames: "localhost,etcd-2,127.0.0.1,172.30.66.11"
+    auth.openshift.io/certificate-etcd-identity: "system:metrics:etcd-2"
+  name: etcd-2-metrics
+  namespace: default
+EOF
+
 
 cat <<EOF | kubectl create -f -
 apiVersion: v1
@@ -63,12 +106,23 @@ apiVersion: v1
 kind: Secret
 metadata:
   annotations:
-    openshift.io/certificate-hostnames: "localhost,etcd-3,127.0.0.1,172.30.66.12"
+    auth.openshift.io/certificate-hostnames: "localhost,etcd-3,127.0.0.1,172.30.66.12"
     auth.openshift.io/certificate-etcd-identity: "system:server:etcd-3"
   name: etcd-3-server
   namespace: default
 EOF
 
+cat <<EOF | kubectl create -f -
+apiVersion: v1
+kind: Secret
+metadata:
+  annotations:
+    auth.openshift.io/certificate-hostnames: "localhost,etcd-3,127.0.0.1,172.30.66.12"
+    auth.openshift.io/certificate-etcd-identity: "system:metrics:etcd-3"
+  name: etcd-3-metrics
+  namespace: default
+EOF
+
 cat <<EOF | kubectl create -f -
 apiVersion: v1
 kind: Pod
@@ -147,14 +201,20 @@ spec:
       mountPath: "/etc/ssl/certs1/server"
     - name: peer-1-certs
       mountPath: "/etc/ssl/certs1/peer"
+    - name: metrics-1-certs
+      mountPath: "/etc/ssl/certs1/metrics"
     - name: server-2-certs
       mountPath: "/etc/ssl/certs2/server"
     - name: peer-2-certs
       mountPath: "/etc/ssl/certs2/peer"
+    - name: metrics-2-certs
+      mountPath: "/etc/ssl/certs2/metrics"
     - name: server-3-certs
       mountPath: "/etc/ssl/certs3/server"
     - name: peer-3-certs
       mountPath: "/etc/ssl/certs3/peer"
+    - name: metrics-3-certs
+      mountPath: "/etc/ssl/certs3/metrics"
   volumes:
   - name: server-1-certs
     secret:
@@ -162,17 +222,26 @@ spec:
   - name: peer-1-certs
     secret:
       secretName: etcd-1-peer
+  - name: metrics-1-certs
+    secret:
+      secretName: etcd-1-metrics
   - name: server-2-certs
     secret:
       secretName: etcd-2-server
   - name: peer-2-certs
     secret:
       secretName: etcd-2-peer
+  - name: metrics-2-certs
+    secret:
+      secretName: etcd-2-metrics
   - name: server-3-certs
     secret:
       secretName: etcd-3-server
   - name: peer-3-certs
     secret:
       secretName: etcd-3-peer
+  - name: metrics-3-certs
+    secret:
+      secretName: etcd-3-metrics
   restartPolicy: Always
 EOF
\ No newline at end of file